@@ -0,0 +1,58 @@
+package main
+
+import "math"
+
+// ==========================================
+// Blame
+// ==========================================
+
+// BlameSpan is a run of content inserted by consecutive ops of one agent,
+// e.g. a word typed in one go.
+type BlameSpan struct {
+	Pos int
+	Len int
+	Id  Id // Insert op of the first item; Id.Agent is the author
+}
+
+// Blame returns who inserted each item of the current content of log. Moved
+// items are attributed to whoever inserted them, not to the last mover.
+func Blame[T any](log *OpLog[T]) []BlameSpan {
+	doc, _ := replayObj(log, RootObj)
+	return blame(doc, log, 0, math.MaxInt)
+}
+
+// blame attributes positions [start, end) of the content of doc, stopping
+// at the first item past end.
+func blame[T any](doc *CRDTDoc, log *OpLog[T], start int, end int) []BlameSpan {
+	spans := []BlameSpan{}
+	pos := 0
+	for _, item := range doc.Items {
+		if pos >= end {
+			break
+		}
+		if !IsVisible(item) {
+			continue
+		}
+		if pos < start {
+			pos++
+			continue
+		}
+		if item.Elem != nil {
+			item = item.Elem.Orig
+		}
+		id := log.Ops[item.LV].Id
+
+		if n := len(spans); n > 0 && spans[n-1].Id.Agent == id.Agent && spans[n-1].Id.Seq+spans[n-1].Len == id.Seq {
+			spans[n-1].Len++
+		} else {
+			spans = append(spans, BlameSpan{Pos: pos, Len: 1, Id: id})
+		}
+		pos++
+	}
+	return spans
+}
+
+// Blame returns the authors of positions [start, end) of the content.
+func (doc *Document[T]) Blame(start int, end int) []BlameSpan {
+	return blame(doc.replayed(), doc.OpLog, start, end)
+}
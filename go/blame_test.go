@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestBlame(t *testing.T) {
+	alice := NewCRDTDocument(1)
+	bob := NewCRDTDocument(2)
+
+	alice.Ins(0, "hello world")
+	bob.MergeFrom(alice)
+	bob.Ins(5, " there")
+	bob.Del(0, 1)
+	bob.Ins(0, "H")
+	alice.MergeFrom(bob)
+
+	// "Hello there world"
+	expected := []BlameSpan{
+		{Pos: 0, Len: 1, Id: Id{Agent: 2, Seq: 7}},
+		{Pos: 1, Len: 4, Id: Id{Agent: 1, Seq: 1}},
+		{Pos: 5, Len: 6, Id: Id{Agent: 2, Seq: 0}},
+		{Pos: 11, Len: 6, Id: Id{Agent: 1, Seq: 5}},
+	}
+	if got := alice.Blame(0, alice.Len()); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+
+	expected = []BlameSpan{
+		{Pos: 3, Len: 2, Id: Id{Agent: 1, Seq: 3}},
+		{Pos: 5, Len: 2, Id: Id{Agent: 2, Seq: 0}},
+	}
+	if got := bob.Blame(3, 7); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestBlameFollowsMoves(t *testing.T) {
+	a := NewDocument[string](1)
+	a.Ins(0, []string{"x", "y"})
+	b := NewDocument[string](2)
+	b.MergeFrom(a)
+	b.Move(0, 1)
+
+	expected := []BlameSpan{
+		{Pos: 0, Len: 1, Id: Id{Agent: 1, Seq: 1}},
+		{Pos: 1, Len: 1, Id: Id{Agent: 1, Seq: 0}},
+	}
+	if got := b.Blame(0, 2); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestBlameRangesRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	docs := []*CRDTDocument{NewCRDTDocument(1), NewCRDTDocument(2)}
+	for i := 0; i < 200; i++ {
+		doc := docs[r.Intn(len(docs))]
+		switch n := doc.Len(); {
+		case r.Intn(5) == 0:
+			doc.MergeFrom(docs[r.Intn(len(docs))])
+		case n == 0 || r.Intn(3) > 0:
+			doc.Ins(r.Intn(n+1), "ab")
+		default:
+			doc.Del(r.Intn(n), 1)
+		}
+
+		// The range is cut out of the blame of the whole content
+		n := doc.Len()
+		start := r.Intn(n + 1)
+		end := start + r.Intn(n-start+1)
+		expected := []BlameSpan{}
+		for _, span := range Blame(doc.OpLog) {
+			from, to := max(span.Pos, start), min(span.Pos+span.Len, end)
+			if from < to {
+				id := span.Id
+				id.Seq += from - span.Pos
+				expected = append(expected, BlameSpan{Pos: from, Len: to - from, Id: id})
+			}
+		}
+		if got := doc.Blame(start, end); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("Iteration %d: blame of [%d, %d) is %v, expected %v", i, start, end, got, expected)
+		}
+	}
+}
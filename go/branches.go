@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// ==========================================
+// Named Branches
+// ==========================================
+
+var (
+	ErrBranchExists   = errors.New("branch already exists")
+	ErrBranchNotFound = errors.New("branch not found")
+	ErrTagExists      = errors.New("tag already exists")
+	ErrTagNotFound    = errors.New("tag not found")
+)
+
+const MainBranch = "main"
+
+// BranchManager keeps named branches and tags over one oplog. Each branch
+// has its own snapshot and frontier; edits to a branch are added to the
+// shared oplog with the branch frontier as parents, so other branches do not
+// see them until they merge it.
+type BranchManager[T any] struct {
+	OpLog *OpLog[T]
+	Agent int
+
+	branches map[string]*Branch[T]
+	tags     map[string][]LV
+}
+
+// NewBranchManager creates a manager whose main branch is at the frontier
+// of log.
+func NewBranchManager[T any](agent int, log *OpLog[T]) *BranchManager[T] {
+	bm := &BranchManager[T]{
+		OpLog:    log,
+		Agent:    agent,
+		branches: make(map[string]*Branch[T]),
+		tags:     make(map[string][]LV),
+	}
+	bm.Create(MainBranch, log.Frontier)
+	return bm
+}
+
+func (bm *BranchManager[T]) checkVersion(version []LV) error {
+	for _, lv := range version {
+		if lv < 0 || int(lv) >= len(bm.OpLog.Ops) {
+			return fmt.Errorf("%w: %d", ErrMissingParent, lv)
+		}
+	}
+	return nil
+}
+
+// Create makes a branch with the content at version.
+func (bm *BranchManager[T]) Create(name string, version []LV) error {
+	if _, ok := bm.branches[name]; ok {
+		return fmt.Errorf("%w: %s", ErrBranchExists, name)
+	}
+	if err := bm.checkVersion(version); err != nil {
+		return err
+	}
+	branch := NewBranch[T]()
+	CheckoutFancy(bm.OpLog, branch, version)
+	bm.branches[name] = branch
+	return nil
+}
+
+func (bm *BranchManager[T]) branch(name string) (*Branch[T], error) {
+	branch, ok := bm.branches[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	return branch, nil
+}
+
+// Delete removes a branch. Its ops stay in the oplog.
+func (bm *BranchManager[T]) Delete(name string) error {
+	if _, err := bm.branch(name); err != nil {
+		return err
+	}
+	delete(bm.branches, name)
+	return nil
+}
+
+// Branches returns the names of the branches, sorted.
+func (bm *BranchManager[T]) Branches() []string {
+	return slices.Sorted(maps.Keys(bm.branches))
+}
+
+// Content returns a copy of the content of a branch.
+func (bm *BranchManager[T]) Content(name string) ([]T, error) {
+	branch, err := bm.branch(name)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(branch.Snapshot), nil
+}
+
+// Frontier returns the version of a branch.
+func (bm *BranchManager[T]) Frontier(name string) ([]LV, error) {
+	branch, err := bm.branch(name)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(branch.Frontier), nil
+}
+
+func (bm *BranchManager[T]) Ins(name string, pos int, content []T) error {
+	branch, err := bm.branch(name)
+	if err != nil {
+		return err
+	}
+	if pos < 0 || pos > len(branch.Snapshot) {
+		return fmt.Errorf("%w: insert at %d in content of length %d", ErrOutOfRange, pos, len(branch.Snapshot))
+	}
+	for i, c := range content {
+		lv := bm.OpLog.PushLocalOpAt(bm.Agent, Op[T]{Type: OpTypeIns, Content: c, Pos: pos + i}, branch.Frontier)
+		branch.Frontier = []LV{lv}
+	}
+	branch.Snapshot = slices.Insert(branch.Snapshot, pos, content...)
+	return nil
+}
+
+func (bm *BranchManager[T]) Del(name string, pos int, delLen int) error {
+	branch, err := bm.branch(name)
+	if err != nil {
+		return err
+	}
+	if pos < 0 || delLen < 0 || pos+delLen > len(branch.Snapshot) {
+		return fmt.Errorf("%w: delete [%d, %d) in content of length %d", ErrOutOfRange, pos, pos+delLen, len(branch.Snapshot))
+	}
+	for range delLen {
+		lv := bm.OpLog.PushLocalOpAt(bm.Agent, Op[T]{Type: OpTypeDel, Pos: pos}, branch.Frontier)
+		branch.Frontier = []LV{lv}
+	}
+	branch.Snapshot = slices.Delete(branch.Snapshot, pos, pos+delLen)
+	return nil
+}
+
+// Merge merges branch from into branch into and returns the patches made to
+// its content. from is left as it is.
+func (bm *BranchManager[T]) Merge(into string, from string) ([]Patch[T], error) {
+	dest, err := bm.branch(into)
+	if err != nil {
+		return nil, err
+	}
+	src, err := bm.branch(from)
+	if err != nil {
+		return nil, err
+	}
+	return CheckoutFancy(bm.OpLog, dest, src.Frontier), nil
+}
+
+// Tag names a version, e.g. the frontier of a branch at a release.
+func (bm *BranchManager[T]) Tag(name string, version []LV) error {
+	if _, ok := bm.tags[name]; ok {
+		return fmt.Errorf("%w: %s", ErrTagExists, name)
+	}
+	if err := bm.checkVersion(version); err != nil {
+		return err
+	}
+	bm.tags[name] = slices.Clone(version)
+	return nil
+}
+
+// TagVersion returns the version a tag names.
+func (bm *BranchManager[T]) TagVersion(name string) ([]LV, error) {
+	version, ok := bm.tags[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTagNotFound, name)
+	}
+	return slices.Clone(version), nil
+}
+
+// Tags returns the names of the tags, sorted.
+func (bm *BranchManager[T]) Tags() []string {
+	return slices.Sorted(maps.Keys(bm.tags))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestBranchManager(t *testing.T) {
+	log := NewOpLog[rune]()
+	LocalInsert(log, 1, 0, []rune("hello"))
+	bm := NewBranchManager(1, log)
+
+	main, _ := bm.Frontier(MainBranch)
+	if err := bm.Create("feature", main); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := bm.Create("feature", main); !errors.Is(err, ErrBranchExists) {
+		t.Fatalf("Expected ErrBranchExists, got %v", err)
+	}
+	if err := bm.Tag("v1", main); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+
+	bm.Ins(MainBranch, 5, []rune(" world"))
+	bm.Del("feature", 0, 1)
+	bm.Ins("feature", 0, []rune("H"))
+
+	content, _ := bm.Content(MainBranch)
+	if string(content) != "hello world" {
+		t.Fatalf("Unexpected main %q", string(content))
+	}
+	content, _ = bm.Content("feature")
+	if string(content) != "Hello" {
+		t.Fatalf("Unexpected feature %q", string(content))
+	}
+
+	patches, err := bm.Merge(MainBranch, "feature")
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	content, _ = bm.Content(MainBranch)
+	if string(content) != "Hello world" {
+		t.Fatalf("Unexpected merged main %q", string(content))
+	}
+	if len(patches) != 1 || patches[0].Pos != 0 || patches[0].DelLen != 1 || string(patches[0].Content) != "H" {
+		t.Fatalf("Unexpected patches %v", patches)
+	}
+	content, _ = bm.Content("feature")
+	if string(content) != "Hello" {
+		t.Fatalf("Merge changed the source branch to %q", string(content))
+	}
+	if string(Checkout(log)) != "Hello world" {
+		t.Fatalf("Unexpected oplog content %q", string(Checkout(log)))
+	}
+
+	// A branch at a tag sees the tagged content
+	v1, _ := bm.TagVersion("v1")
+	bm.Create("hotfix", v1)
+	content, _ = bm.Content("hotfix")
+	if string(content) != "hello" {
+		t.Fatalf("Unexpected hotfix %q", string(content))
+	}
+
+	if !slices.Equal(bm.Branches(), []string{"feature", "hotfix", "main"}) || !slices.Equal(bm.Tags(), []string{"v1"}) {
+		t.Fatalf("Unexpected branches %v and tags %v", bm.Branches(), bm.Tags())
+	}
+	if _, err := bm.Content("missing"); !errors.Is(err, ErrBranchNotFound) {
+		t.Fatalf("Expected ErrBranchNotFound, got %v", err)
+	}
+}
+
+func TestBranchMergeConverges(t *testing.T) {
+	log := NewOpLog[rune]()
+	LocalInsert(log, 1, 0, []rune("ab"))
+	bm := NewBranchManager(1, log)
+	main, _ := bm.Frontier(MainBranch)
+	bm.Create("x", main)
+	bm.Create("y", main)
+
+	// Both branches insert at the same place under the same agent
+	bm.Ins("x", 1, []rune("X"))
+	bm.Ins("y", 1, []rune("Y"))
+	x, _ := bm.Frontier("x")
+	y, _ := bm.Frontier("y")
+	bm.Create("x2", x)
+	bm.Create("y2", y)
+
+	bm.Merge("x2", "y")
+	bm.Merge("y2", "x")
+	xy, _ := bm.Content("x2")
+	yx, _ := bm.Content("y2")
+	if string(xy) != string(yx) {
+		t.Fatalf("Merges diverged: %q and %q", string(xy), string(yx))
+	}
+	if string(Checkout(log)) != string(xy) {
+		t.Fatalf("Oplog content %q, merged %q", string(Checkout(log)), string(xy))
+	}
+}
+
+func TestBranchEditsOutOfRange(t *testing.T) {
+	log := NewOpLog[rune]()
+	bm := NewBranchManager(1, log)
+	if err := bm.Ins(MainBranch, 5, []rune("x")); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("Expected ErrOutOfRange, got %v", err)
+	}
+	bm.Ins(MainBranch, 0, []rune("ab"))
+	for _, del := range [][2]int{{-1, 1}, {1, 2}, {0, -1}} {
+		if err := bm.Del(MainBranch, del[0], del[1]); !errors.Is(err, ErrOutOfRange) {
+			t.Fatalf("Expected ErrOutOfRange for %v, got %v", del, err)
+		}
+	}
+	if len(log.Ops) != 2 || string(Checkout(log)) != "ab" {
+		t.Fatalf("Rejected edits changed the oplog: %d ops, %q", len(log.Ops), string(Checkout(log)))
+	}
+}
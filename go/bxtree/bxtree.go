@@ -1,12 +1,23 @@
 package bxtree
 
-import "fmt"
-
-func New[T any]() *BxTree[T] {
+import (
+	"fmt"
+	"slices"
+)
+
+// New creates an empty tree. It panics if the options are invalid.
+func New[T any](opts ...Option) *BxTree[T] {
+	c, measures, err := newConfig[T](opts)
+	if err != nil {
+		panic(err)
+	}
 	return &BxTree[T]{
-		root:  nil,
-		first: nil,
-		last:  nil,
+		root:     nil,
+		first:    nil,
+		last:     nil,
+		sizes:    c.sizes,
+		handles:  c.handles,
+		measures: measures,
 	}
 }
 
@@ -28,6 +39,21 @@ func (tree *BxTree[T]) ForEach(f func(item T)) {
 	}
 }
 
+// IndexFunc returns the index of the first item satisfying f, or -1 if
+// there is none. It scans the leaves along their chain.
+func (tree *BxTree[T]) IndexFunc(f func(item T) bool) int {
+	index := 0
+	for leaf := tree.first; leaf != nil; leaf = leaf.next {
+		for i, item := range leaf.items {
+			if f(item) {
+				return index + i
+			}
+		}
+		index += len(leaf.items)
+	}
+	return -1
+}
+
 //
 
 func (tree *BxTree[T]) Print() {
@@ -70,6 +96,18 @@ func (tree *BxTree[T]) GetAt(index int) (*T, error) {
 	return &node.items[index], nil
 }
 
+// SetAt replaces the item at index, updating the sums of the measures.
+func (tree *BxTree[T]) SetAt(index int, item T) error {
+	node, index, err := tree.getAt(index)
+	if err != nil {
+		return err
+	}
+	node.items[index] = item
+	tree.touch(node)
+	tree.fixSums()
+	return nil
+}
+
 func (tree *BxTree[T]) getAt(index int) (*node[T], int, error) {
 	if index < 0 || index >= tree.Size() {
 		return nil, -1, ErrIndexOutOfBounds
@@ -104,15 +142,66 @@ func (node *node[T]) getAt(index int) (*node[T], int, error) {
 
 //
 
-// OPTIMIZE: do not go down the tree for each insert, but adapt
-// the leaf & position if the insert caused a rebalance
+// InsertRange inserts items at index in one descent: the leaf at index is
+// refilled with its items and the new ones, cut into as many leaves as
+// needed, and the new leaves are added to the parent in one go.
 func (tree *BxTree[T]) InsertRange(index int, items []T) error {
-	for i, item := range items {
-		err := tree.InsertAt(index+i, item)
-		if err != nil {
-			return err
+	if index < 0 || index > tree.Size() {
+		return ErrIndexOutOfBounds
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	if tree.root == nil {
+		tree.root = &node[T]{isLeaf: true}
+		tree.first = tree.root
+		tree.last = tree.root
+	}
+
+	leaf, position := tree.last, tree.last.size
+	if index < tree.Size() {
+		leaf, position, _ = tree.getAt(index)
+	}
+	if leaf.size+len(items) <= tree.leafMax() {
+		// Fits in the leaf, e.g. typing: no leaves to rebuild
+		leaf.items = slices.Insert(leaf.items, position, items...)
+		if tree.handles {
+			leaf.handles = slices.Insert(leaf.handles, position, make([]*Handle[T], len(items))...)
 		}
+		leaf.size += len(items)
+		leaf.updateParentSizeUpwards(len(items))
+		tree.touch(leaf)
+		tree.fixSums()
+		return nil
 	}
+	merged := make([]T, 0, leaf.size+len(items))
+	merged = append(merged, leaf.items[:position]...)
+	merged = append(merged, items...)
+	merged = append(merged, leaf.items[position:]...)
+	var mergedHandles []*Handle[T]
+	if tree.handles {
+		mergedHandles = slices.Concat(leaf.handles[:position], make([]*Handle[T], len(items)), leaf.handles[position:])
+	}
+
+	parts := splitEven(merged, tree.leafMax())
+	handles := splitInto(mergedHandles, len(parts))
+	leaf.items, leaf.handles = parts[0], handles[0]
+	tree.refresh(leaf)
+	leaves := make([]*node[T], 0, len(parts)-1)
+	prev := leaf
+	for i, part := range parts[1:] {
+		right := &node[T]{isLeaf: true, items: part, handles: handles[i+1]}
+		adopt(right)
+		tree.refresh(right)
+		link(right, prev.next)
+		link(prev, right)
+		leaves = append(leaves, right)
+		prev = right
+	}
+	if tree.last == leaf {
+		tree.last = prev
+	}
+	tree.addSiblings(leaf, leaves)
 	return nil
 }
 
@@ -120,6 +209,7 @@ func (tree *BxTree[T]) InsertAt(index int, item T) error {
 	if index < 0 || index > tree.Size() {
 		return ErrIndexOutOfBounds
 	}
+	defer tree.fixSums()
 	if tree.root == nil {
 		leaf := &node[T]{
 			isLeaf:   true,
@@ -129,9 +219,13 @@ func (tree *BxTree[T]) InsertAt(index int, item T) error {
 			size:     1,
 			items:    []T{item},
 		}
+		if tree.handles {
+			leaf.handles = []*Handle[T]{nil}
+		}
 		tree.root = leaf
 		tree.first = leaf
 		tree.last = leaf
+		tree.touch(leaf)
 		return nil
 	}
 
@@ -155,14 +249,14 @@ func (_node *node[T]) split() (*node[T], *node[T]) {
 		children: nil,
 	}
 	if _node.isLeaf {
-		right_len := len(_node.items) / 2
-		right.items = make([]T, right_len)
-		copy(right.items, _node.items[right_len:])
+		left_len := len(_node.items) / 2
+		right.items = make([]T, len(_node.items)-left_len)
+		copy(right.items, _node.items[left_len:])
 		right.size = len(right.items)
-		_node.items = _node.items[:right_len]
+		_node.items = _node.items[:left_len]
 		_node.size = len(_node.items)
-		right.next = _node.next
-		_node.next = right
+		link(right, _node.next)
+		link(_node, right)
 	} else {
 		right_len := len(_node.children) / 2
 		right.children = make([]*node[T], right_len)
@@ -188,13 +282,15 @@ func (tree *BxTree[T]) insertInternal(_node *node[T], new_node *node[T], index i
 	}
 
 	_node.size += upward_change
-	if len(_node.children) < INTERNAL_MAX_SIZE {
+	if len(_node.children) < tree.internalMax() {
 		insert(_node, index)
 		_node.updateParentSizeUpwards(upward_change + new_node.size)
 		return nil
 	}
 
 	_, right := _node.split()
+	tree.touch(_node)
+	tree.touch(right)
 	upward_change -= right.size
 	if index <= len(_node.children) {
 		insert(_node, index)
@@ -230,13 +326,23 @@ func (tree *BxTree[T]) insertLeaf(leaf *node[T], item T, index int) error {
 		copy(node.items[at+1:], node.items[at:])
 		node.items[at] = item
 		node.size++
+		if tree.handles {
+			node.handles = slices.Insert(node.handles, at, nil)
+		}
 	}
-	if leaf.size < LEAF_MAX_SIZE {
+	tree.touch(leaf)
+	if leaf.size < tree.leafMax() {
 		insert(leaf, index)
 		leaf.updateParentSizeUpwards(1)
 		return nil
 	}
 	_, right := leaf.split()
+	if tree.handles {
+		right.handles = slices.Clone(leaf.handles[len(leaf.items):])
+		leaf.handles = leaf.handles[:len(leaf.items)]
+		adopt(right)
+	}
+	tree.touch(right)
 	if tree.last == leaf {
 		tree.last = right
 	}
@@ -273,14 +379,172 @@ func (tree *BxTree[T]) insertLeaf(leaf *node[T], item T, index int) error {
 
 //
 
-// OPTIMIZE: do not go down the tree for each delete, but adapt
-// the leaf & position if the delete caused a rebalance
+// DeleteRange removes length items starting at index in one pass: subtrees
+// inside the range are dropped whole, and only the nodes along its two
+// edges are rebalanced.
 func (tree *BxTree[T]) DeleteRange(index int, length int) error {
-	for range length {
-		err := tree.DeleteAt(index)
-		if err != nil {
+	if index < 0 || length < 0 || index+length > tree.Size() {
+		return ErrIndexOutOfBounds
+	}
+	if length == 0 {
+		return nil
+	}
+	tree.deleteRange(tree.root, index, index+length)
+	for !tree.root.isLeaf && len(tree.root.children) == 1 {
+		tree.root = tree.root.children[0]
+		tree.root.parent = nil
+	}
+	if tree.root.size == 0 {
+		tree.root, tree.first, tree.last = nil, nil, nil
+		return nil
+	}
+	tree.relink(index)
+	return nil
+}
+
+// deleteRange removes the items [from, to) of the subtree n. Children of n
+// left underfull are joined with their siblings; n itself may be left
+// underfull for its parent to fix.
+func (tree *BxTree[T]) deleteRange(n *node[T], from int, to int) {
+	if n.isLeaf {
+		n.items = slices.Delete(n.items, from, to)
+		if tree.handles {
+			drop(n.handles[from:to])
+			n.handles = slices.Delete(n.handles, from, to)
+		}
+		tree.refresh(n)
+		return
+	}
+	kept := make([]*node[T], 0, len(n.children))
+	partial := []int{}
+	start := 0
+	for _, child := range n.children {
+		end := start + child.size
+		switch {
+		case end <= from || start >= to:
+			kept = append(kept, child)
+		case from <= start && end <= to:
+			// Dropped with its whole subtree
+			if tree.handles {
+				dropSubtree(child)
+			}
+		default:
+			tree.deleteRange(child, max(from-start, 0), min(to, end)-start)
+			kept = append(kept, child)
+			partial = append(partial, len(kept)-1)
+		}
+		start = end
+	}
+	if len(partial) == 2 {
+		// The two edges of the range are now next to each other
+		kept = slices.Replace(kept, partial[0], partial[1]+1, tree.join(kept[partial[0]], kept[partial[1]])...)
+	}
+	if len(partial) > 0 {
+		kept = tree.fixAt(kept, partial[0])
+	}
+	n.setChildren(kept)
+	tree.refresh(n)
+}
+
+// join rebalances two neighbouring nodes of the same height, either of
+// which may be underfull along their shared edge, into one or two nodes
+// that are not (unless everything fits in one underfull node).
+func (tree *BxTree[T]) join(left *node[T], right *node[T]) []*node[T] {
+	if left.isLeaf {
+		items := append(left.items, right.items...)
+		handles := append(left.handles, right.handles...)
+		if len(items) <= tree.leafMax() {
+			left.items, left.handles = items, handles
+			adopt(left)
+			link(left, right.next)
+			tree.refresh(left)
+			return []*node[T]{left}
+		}
+		parts, handleParts := splitInto(items, 2), splitInto(handles, 2)
+		left.items, right.items = parts[0], parts[1]
+		if tree.handles {
+			left.handles, right.handles = handleParts[0], handleParts[1]
+			adopt(left)
+			adopt(right)
+		}
+		link(left, right)
+		tree.refresh(left)
+		tree.refresh(right)
+		return []*node[T]{left, right}
+	}
+
+	edge := len(left.children)
+	children := append(slices.Clone(left.children), right.children...)
+	if tree.underfull(children[edge-1]) || tree.underfull(children[edge]) {
+		children = slices.Replace(children, edge-1, edge+1, tree.join(children[edge-1], children[edge])...)
+		children = tree.fixAt(children, edge-1)
+	}
+	if len(children) <= tree.internalMax() {
+		left.setChildren(children)
+		tree.refresh(left)
+		return []*node[T]{left}
+	}
+	half := len(children) / 2
+	left.setChildren(slices.Clone(children[:half]))
+	right.setChildren(slices.Clone(children[half:]))
+	tree.refresh(left)
+	tree.refresh(right)
+	return []*node[T]{left, right}
+}
+
+// fixAt joins the node at index of siblings with its neighbours until it is
+// no longer underfull or it is the only one left.
+func (tree *BxTree[T]) fixAt(siblings []*node[T], index int) []*node[T] {
+	for len(siblings) > 1 && tree.underfull(siblings[index]) {
+		if index > 0 {
+			index--
+		}
+		siblings = slices.Replace(siblings, index, index+2, tree.join(siblings[index], siblings[index+1])...)
+	}
+	return siblings
+}
+
+// relink points the leaf before index to the leaf after it, once the
+// leaves in between are gone, and finds first and last again.
+func (tree *BxTree[T]) relink(index int) {
+	tree.findEnds()
+	if index == 0 || index >= tree.Size() {
+		return
+	}
+	before, _, _ := tree.root.getAt(index - 1)
+	after, _, _ := tree.root.getAt(index)
+	if before != after {
+		link(before, after)
+	}
+}
+
+// ReplaceRange replaces the length items starting at index with items.
+// When as many items come in as go out they are overwritten in place, so
+// no node is split or merged.
+func (tree *BxTree[T]) ReplaceRange(index int, length int, items []T) error {
+	if index < 0 || length < 0 || index+length > tree.Size() {
+		return ErrIndexOutOfBounds
+	}
+	if length != len(items) {
+		if err := tree.DeleteRange(index, length); err != nil {
 			return err
 		}
+		return tree.InsertRange(index, items)
+	}
+	if length == 0 {
+		return nil
+	}
+
+	defer tree.fixSums()
+	leaf, offset, _ := tree.getAt(index)
+	tree.touch(leaf)
+	for _, item := range items {
+		if offset == len(leaf.items) {
+			leaf, offset = leaf.next, 0
+			tree.touch(leaf)
+		}
+		leaf.items[offset] = item
+		offset++
 	}
 	return nil
 }
@@ -293,17 +557,20 @@ func (tree *BxTree[T]) DeleteAt(index int) error {
 	if err != nil {
 		return err
 	}
+	defer tree.fixSums()
 	return tree.deleteLeaf(leaf, position)
 }
 
 func (tree *BxTree[T]) deleteInternal(_node *node[T], index int, upward_change int) error {
+	tree.touch(_node)
+	_node.size += upward_change
 	del_size := _node.children[index].size
 	copy(_node.children[index:], _node.children[index+1:])
 	_node.children = _node.children[:len(_node.children)-1]
 	_node.size -= del_size
 
 	upward_change -= del_size
-	if len(_node.children) >= INTERNAL_MIN_SIZE || (_node.parent == nil && len(_node.children) > 1) {
+	if len(_node.children) >= tree.internalMin || (_node.parent == nil && len(_node.children) > 1) {
 		_node.updateParentSizeUpwards(upward_change)
 		return nil
 	}
@@ -315,7 +582,8 @@ func (tree *BxTree[T]) deleteInternal(_node *node[T], index int, upward_change i
 	parent_index := _node.getParentIndex()
 	if parent_index > 0 {
 		left_sibling := _node.parent.children[parent_index-1]
-		if tryBorrowFromLeftSibling(_node, left_sibling) {
+		if tree.tryBorrowFromLeftSibling(_node, left_sibling) {
+			tree.touch(left_sibling)
 			_node.updateParentSizeUpwards(upward_change)
 			return nil
 		} else {
@@ -325,7 +593,8 @@ func (tree *BxTree[T]) deleteInternal(_node *node[T], index int, upward_change i
 	}
 	if parent_index < len(_node.parent.children)-1 {
 		right_sibling := _node.parent.children[parent_index+1]
-		if tryBorrowFromRightSibling(_node, right_sibling) {
+		if tree.tryBorrowFromRightSibling(_node, right_sibling) {
+			tree.touch(right_sibling)
 			_node.updateParentSizeUpwards(upward_change)
 			return nil
 		} else {
@@ -337,18 +606,24 @@ func (tree *BxTree[T]) deleteInternal(_node *node[T], index int, upward_change i
 }
 
 func (tree *BxTree[T]) deleteLeaf(leaf *node[T], index int) error {
+	tree.touch(leaf)
+	if tree.handles {
+		drop(leaf.handles[index : index+1])
+		leaf.handles = slices.Delete(leaf.handles, index, index+1)
+	}
 	copy(leaf.items[index:], leaf.items[index+1:])
 	leaf.items = leaf.items[:leaf.size-1]
 	leaf.size -= 1
 
-	if leaf.size >= LEAF_MIN_SIZE || leaf.parent == nil {
+	if leaf.size >= tree.leafMin || leaf.parent == nil {
 		leaf.updateParentSizeUpwards(-1)
 		return nil
 	}
 	parent_index := leaf.getParentIndex()
 	if parent_index > 0 {
 		left_sibling := leaf.parent.children[parent_index-1]
-		if tryBorrowFromLeftSibling(leaf, left_sibling) {
+		if tree.tryBorrowFromLeftSibling(leaf, left_sibling) {
+			tree.touch(left_sibling)
 			leaf.updateParentSizeUpwards(-1)
 			return nil
 		} else {
@@ -358,7 +633,8 @@ func (tree *BxTree[T]) deleteLeaf(leaf *node[T], index int) error {
 	}
 	if parent_index < len(leaf.parent.children)-1 {
 		right_sibling := leaf.parent.children[parent_index+1]
-		if tryBorrowFromRightSibling(leaf, right_sibling) {
+		if tree.tryBorrowFromRightSibling(leaf, right_sibling) {
+			tree.touch(right_sibling)
 			leaf.updateParentSizeUpwards(-1)
 			return nil
 		} else {
@@ -370,10 +646,13 @@ func (tree *BxTree[T]) deleteLeaf(leaf *node[T], index int) error {
 }
 
 func (tree *BxTree[T]) merge(left *node[T], right *node[T]) {
+	tree.touch(left)
 	left.size += right.size
 	if left.isLeaf {
 		left.items = append(left.items, right.items...)
-		left.next = right.next
+		left.handles = append(left.handles, right.handles...)
+		adopt(left)
+		link(left, right.next)
 		if tree.last == right {
 			tree.last = left
 		}
@@ -385,25 +664,34 @@ func (tree *BxTree[T]) merge(left *node[T], right *node[T]) {
 	}
 }
 
+// The sibling can only lend if it stays at least half full, otherwise the
+// two fit in one node and are merged instead.
 // OPTIMIZE: do not borrow only 1 element but make both nodes of equal length
-func tryBorrowFromLeftSibling[T any](_node *node[T], sibling *node[T]) bool {
+func (tree *BxTree[T]) tryBorrowFromLeftSibling(_node *node[T], sibling *node[T]) bool {
 	if _node.isLeaf {
-		if _node.size <= LEAF_MIN_SIZE {
+		if sibling.size <= tree.leafMin {
 			return false
 		}
 		borrowed := sibling.items[sibling.size-1]
 		sibling.items = sibling.items[:sibling.size-1]
 		_node.items = append([]T{borrowed}, _node.items...)
+		if tree.handles {
+			handle := sibling.handles[len(sibling.handles)-1]
+			sibling.handles = sibling.handles[:len(sibling.handles)-1]
+			_node.handles = append([]*Handle[T]{handle}, _node.handles...)
+			adopt(_node)
+		}
 		sibling.size -= 1
 		_node.size += 1
 		return true
 	} else {
-		if len(_node.children) <= INTERNAL_MIN_SIZE {
+		if len(sibling.children) <= tree.internalMin {
 			return false
 		}
 		borrowed := sibling.children[len(sibling.children)-1]
 		sibling.children = sibling.children[:len(sibling.children)-1]
 		_node.children = append([]*node[T]{borrowed}, _node.children...)
+		borrowed.parent = _node
 		sibling.size -= borrowed.size
 		_node.size += borrowed.size
 		return true
@@ -412,24 +700,30 @@ func tryBorrowFromLeftSibling[T any](_node *node[T], sibling *node[T]) bool {
 }
 
 // OPTIMIZE: do not borrow only 1 element but make both nodes of equal length
-func tryBorrowFromRightSibling[T any](_node *node[T], sibling *node[T]) bool {
+func (tree *BxTree[T]) tryBorrowFromRightSibling(_node *node[T], sibling *node[T]) bool {
 	if _node.isLeaf {
-		if _node.size <= LEAF_MIN_SIZE {
+		if sibling.size <= tree.leafMin {
 			return false
 		}
 		borrowed := sibling.items[0]
 		sibling.items = sibling.items[1:]
 		_node.items = append(_node.items, borrowed)
+		if tree.handles {
+			_node.handles = append(_node.handles, sibling.handles[0])
+			sibling.handles = sibling.handles[1:]
+			adopt(_node)
+		}
 		sibling.size -= 1
 		_node.size += 1
 		return true
 	} else {
-		if len(_node.children) <= INTERNAL_MIN_SIZE {
+		if len(sibling.children) <= tree.internalMin {
 			return false
 		}
 		borrowed := sibling.children[0]
 		sibling.children = sibling.children[1:]
 		_node.children = append(_node.children, borrowed)
+		borrowed.parent = _node
 		sibling.size -= borrowed.size
 		_node.size += borrowed.size
 		return true
@@ -455,3 +749,100 @@ func (node *node[T]) updateParentSizeUpwards(delta int) {
 		parent.size += delta
 	}
 }
+
+// splitEven cuts s into the fewest parts of at most limit elements, with
+// lengths that differ by at most one.
+func splitEven[E any](s []E, limit int) [][]E {
+	return splitInto(s, max(1, (len(s)+limit-1)/limit))
+}
+
+// splitInto cuts s into count parts with lengths that differ by at most one.
+func splitInto[E any](s []E, count int) [][]E {
+	parts := make([][]E, count)
+	for i := range count {
+		parts[i] = slices.Clone(s[i*len(s)/count : (i+1)*len(s)/count])
+	}
+	return parts
+}
+
+// refresh recomputes the size and sums of a node from its items or
+// children.
+func (tree *BxTree[T]) refresh(n *node[T]) {
+	if n.isLeaf {
+		n.size = len(n.items)
+	} else {
+		n.size = 0
+		for _, child := range n.children {
+			n.size += child.size
+		}
+	}
+	if len(tree.measures) > 0 {
+		tree.recompute(n)
+	}
+}
+
+// addSiblings inserts nodes right after n in its parent, splitting the
+// parent, and so on upwards, when it overflows. The sizes and sums of n
+// and nodes must be up to date.
+func (tree *BxTree[T]) addSiblings(n *node[T], nodes []*node[T]) {
+	for len(nodes) > 0 {
+		if n.parent == nil {
+			tree.root = &node[T]{children: []*node[T]{n}}
+			n.parent = tree.root
+		}
+		parent := n.parent
+		children := slices.Insert(parent.children, n.getParentIndex()+1, nodes...)
+		groups := splitEven(children, tree.internalMax())
+		parent.setChildren(groups[0])
+		tree.refresh(parent)
+		nodes = nodes[:0]
+		for _, group := range groups[1:] {
+			right := &node[T]{}
+			right.setChildren(group)
+			tree.refresh(right)
+			nodes = append(nodes, right)
+		}
+		n = parent
+	}
+	for p := n.parent; p != nil; p = p.parent {
+		tree.refresh(p)
+	}
+}
+
+// findEnds finds the first and last leaf from the root and cuts the leaf
+// chain at both of them.
+func (tree *BxTree[T]) findEnds() {
+	tree.first, tree.last = tree.root, tree.root
+	for !tree.first.isLeaf {
+		tree.first = tree.first.children[0]
+	}
+	for !tree.last.isLeaf {
+		tree.last = tree.last.children[len(tree.last.children)-1]
+	}
+	tree.first.prev = nil
+	tree.last.next = nil
+}
+
+// link makes right the leaf after left; either may be nil.
+func link[T any](left *node[T], right *node[T]) {
+	if left != nil {
+		left.next = right
+	}
+	if right != nil {
+		right.prev = left
+	}
+}
+
+func (_node *node[T]) setChildren(children []*node[T]) {
+	_node.children = children
+	for _, child := range children {
+		child.parent = _node
+	}
+}
+
+func (tree *BxTree[T]) underfull(n *node[T]) bool {
+	if n.isLeaf {
+		return len(n.items) < tree.leafMin
+	}
+	return len(n.children) < tree.internalMin
+}
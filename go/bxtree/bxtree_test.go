@@ -2,6 +2,7 @@ package bxtree
 
 import (
 	"math/rand"
+	"slices"
 	"testing"
 )
 
@@ -265,6 +266,206 @@ func TestFirstLastPointersAfterDelete(t *testing.T) {
 	}
 }
 
+func TestRandomInsertDelete(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	tree := New[int]()
+	list := []int{}
+
+	// Merges leave leaves of odd and oversized lengths, which used to lose
+	// items when split and to corrupt the sizes of internal nodes
+	for i := 0; i < 20000; i++ {
+		if len(list) == 0 || r.Float64() < 0.5 {
+			index := r.Intn(len(list) + 1)
+			if err := tree.InsertAt(index, i); err != nil {
+				t.Fatalf("InsertAt failed at iteration %d: %v", i, err)
+			}
+			list = append(list[:index], append([]int{i}, list[index:]...)...)
+		} else {
+			index := r.Intn(len(list))
+			if err := tree.DeleteAt(index); err != nil {
+				t.Fatalf("DeleteAt failed at iteration %d: %v", i, err)
+			}
+			list = append(list[:index], list[index+1:]...)
+		}
+		if tree.Size() != len(list) {
+			t.Fatalf("Size mismatch at iteration %d. Expected %d, got %d", i, len(list), tree.Size())
+		}
+	}
+
+	j := 0
+	tree.ForEach(func(item int) {
+		if item != list[j] {
+			t.Fatalf("Data corruption at index %d. Expected %d, got %d", j, list[j], item)
+		}
+		j++
+	})
+}
+
+func TestSplitOddLeaf(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	leaf := &node[int]{isLeaf: true, items: slices.Clone(items), size: len(items)}
+	left, right := leaf.split()
+	if got := append(slices.Clone(left.items), right.items...); !slices.Equal(got, items) {
+		t.Fatalf("Split of %v gave %v and %v", items, left.items, right.items)
+	}
+	if left.size != len(left.items) || right.size != len(right.items) {
+		t.Fatalf("Sizes %d and %d for %d and %d items", left.size, right.size, len(left.items), len(right.items))
+	}
+}
+
+func TestDeleteMergesInternalNodes(t *testing.T) {
+	// Small nodes so that deletes merge internal nodes on several levels
+	tree := New[int](WithLeafSize(1), WithInternalSize(2))
+	list := []int{}
+	for i := 0; i < 300; i++ {
+		tree.InsertAt(i, i)
+		list = append(list, i)
+	}
+	r := rand.New(rand.NewSource(2))
+	for len(list) > 0 {
+		index := r.Intn(len(list))
+		if err := tree.DeleteAt(index); err != nil {
+			t.Fatalf("DeleteAt(%d) failed: %v", index, err)
+		}
+		list = slices.Delete(list, index, index+1)
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("After deleting at %d with %d items left: %v", index, len(list), err)
+		}
+	}
+	checkTree(t, tree, list)
+}
+
+func TestRandomRanges(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	tree := NewMeasured(NewMeasure(func(v int) int { return v % 3 }))
+	list := []int{}
+
+	for i := 0; i < 3000; i++ {
+		if len(list) < 50000 && r.Float64() < 0.55 {
+			index := r.Intn(len(list) + 1)
+			items := make([]int, r.Intn(3000))
+			for j := range items {
+				items[j] = r.Int()
+			}
+			if err := tree.InsertRange(index, items); err != nil {
+				t.Fatalf("InsertRange failed at iteration %d: %v", i, err)
+			}
+			list = append(list[:index], append(items, list[index:]...)...)
+		} else {
+			index := r.Intn(len(list) + 1)
+			length := r.Intn(len(list) - index + 1)
+			if err := tree.DeleteRange(index, length); err != nil {
+				t.Fatalf("DeleteRange failed at iteration %d: %v", i, err)
+			}
+			list = append(list[:index], list[index+length:]...)
+		}
+		checkTree(t, tree, list)
+	}
+}
+
+func TestDeleteRangeOutOfBounds(t *testing.T) {
+	tree := New[int]()
+	tree.InsertRange(0, []int{1, 2, 3})
+	for _, r := range [][2]int{{-1, 1}, {2, 2}, {0, -1}, {4, 0}} {
+		if err := tree.DeleteRange(r[0], r[1]); err != ErrIndexOutOfBounds {
+			t.Errorf("Expected ErrIndexOutOfBounds for DeleteRange(%d, %d), got %v", r[0], r[1], err)
+		}
+	}
+}
+
+// checkTree compares tree with list and validates its structure.
+func checkTree(t *testing.T, tree *BxTree[int], list []int) {
+	t.Helper()
+	if tree.Size() != len(list) {
+		t.Fatalf("Size mismatch. Expected %d, got %d", len(list), tree.Size())
+	}
+	got := []int{}
+	tree.ForEach(func(item int) { got = append(got, item) })
+	if !slices.Equal(got, list) {
+		t.Fatalf("Content mismatch over the leaf chain")
+	}
+	backward := slices.Collect(tree.Backward())
+	slices.Reverse(backward)
+	if !slices.Equal(backward, list) {
+		t.Fatalf("Content mismatch over the reversed leaf chain")
+	}
+	for _, m := range tree.measures {
+		sum := 0
+		for _, v := range list {
+			sum += m.of(v)
+		}
+		if tree.Sum(m) != sum {
+			t.Fatalf("Sum mismatch. Expected %d, got %d", sum, tree.Sum(m))
+		}
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetAndReplace(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	list := make([]int, 5000)
+	for i := range list {
+		list[i] = i
+	}
+	tree, _ := FromSlice(list, 0.75, WithMeasures(NewMeasure(func(v int) int { return v % 3 })))
+
+	for i := 0; i < 2000; i++ {
+		index := r.Intn(len(list))
+		switch r.Intn(3) {
+		case 0:
+			if err := tree.SetAt(index, -i); err != nil {
+				t.Fatalf("SetAt failed: %v", err)
+			}
+			list[index] = -i
+		case 1:
+			// Same length, overwritten in place across leaves
+			items := make([]int, r.Intn(min(len(list)-index, 300)+1))
+			for j := range items {
+				items[j] = r.Int()
+			}
+			if err := tree.ReplaceRange(index, len(items), items); err != nil {
+				t.Fatalf("ReplaceRange failed: %v", err)
+			}
+			copy(list[index:], items)
+		default:
+			length := r.Intn(min(len(list)-index, 100) + 1)
+			items := make([]int, r.Intn(100))
+			for j := range items {
+				items[j] = r.Int()
+			}
+			if err := tree.ReplaceRange(index, length, items); err != nil {
+				t.Fatalf("ReplaceRange failed: %v", err)
+			}
+			list = slices.Replace(list, index, index+length, items...)
+		}
+		checkTree(t, tree, list)
+	}
+
+	if err := tree.SetAt(len(list), 0); err != ErrIndexOutOfBounds {
+		t.Errorf("Expected ErrIndexOutOfBounds from SetAt, got %v", err)
+	}
+	if err := tree.ReplaceRange(len(list)-1, 2, []int{1, 2}); err != ErrIndexOutOfBounds {
+		t.Errorf("Expected ErrIndexOutOfBounds from ReplaceRange, got %v", err)
+	}
+}
+
+func TestIndexFunc(t *testing.T) {
+	tree := New[int]()
+	for i := range 1000 {
+		tree.InsertAt(i, i*2)
+	}
+	for _, want := range []int{0, 1, 127, 128, 500, 999} {
+		if got := tree.IndexFunc(func(v int) bool { return v == want*2 }); got != want {
+			t.Errorf("IndexFunc for %d returned %d", want*2, got)
+		}
+	}
+	if got := tree.IndexFunc(func(v int) bool { return v%2 == 1 }); got != -1 {
+		t.Errorf("Expected -1 for a missing item, got %d", got)
+	}
+}
+
 const (
 	SmallSize  = 1_000
 	MediumSize = 10_000
@@ -344,6 +545,19 @@ func BenchmarkInsertRandom_BxTree_Large(b *testing.B) {
 	}
 }
 
+func BenchmarkInsertRangeRandom_BxTree_Large(b *testing.B) {
+	tree := New[int]()
+	for i := 0; i < LargeSize; i++ {
+		tree.InsertAt(tree.Size(), i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		tree.InsertRange(rand.Intn(tree.Size()), []int{n})
+	}
+}
+
 func BenchmarkAppend_Slice_Large(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		list := NewList[int]()
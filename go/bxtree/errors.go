@@ -6,4 +6,11 @@ var (
 	ErrIndexOutOfBounds       = errors.New("index out of bounds")
 	ErrNotRootAndOneChild     = errors.New("node is not root and has only one child")
 	ErrParentDoesNotHaveChild = errors.New("parent does not have this node as child")
+	ErrInvalidFillFactor      = errors.New("fill factor must be in (0, 1]")
+	ErrIncompatibleTrees      = errors.New("trees do not have the same options")
+	ErrInvalidHandle          = errors.New("handle does not point into this tree")
+	ErrInvalidOption          = errors.New("invalid tree option")
+	ErrInvalidTree            = errors.New("invalid tree structure")
+	ErrNoHandles              = errors.New("tree does not keep handles")
+	ErrUnknownMeasure         = errors.New("measure is not maintained by this tree")
 )
@@ -0,0 +1,82 @@
+package bxtree
+
+import "slices"
+
+// Handle follows one item of a tree with handles (see WithHandles) while
+// other items are inserted and deleted around it. Leaves keep the handles
+// of their items next to them and repoint them whenever items move to
+// another leaf, so the index of the item can be found from its handle by
+// walking up the parents instead of scanning the tree.
+//
+// Handles belong to a position, not to a value: SetAt and ReplaceRange of
+// the same length keep them on the new items.
+type Handle[T any] struct {
+	leaf *node[T] // nil once the item is deleted
+}
+
+// Handle returns the handle of the item at index, creating it on first
+// use.
+func (tree *BxTree[T]) Handle(index int) (*Handle[T], error) {
+	if !tree.handles {
+		return nil, ErrNoHandles
+	}
+	leaf, offset, err := tree.getAt(index)
+	if err != nil {
+		return nil, err
+	}
+	if leaf.handles[offset] == nil {
+		leaf.handles[offset] = &Handle[T]{leaf: leaf}
+	}
+	return leaf.handles[offset], nil
+}
+
+// IndexOf returns the current index of the item of h, in O(log n). It fails
+// if the item has been deleted or is in another tree.
+func (tree *BxTree[T]) IndexOf(h *Handle[T]) (int, error) {
+	if h.leaf == nil {
+		return -1, ErrInvalidHandle
+	}
+	index := slices.Index(h.leaf.handles, h)
+	n := h.leaf
+	for ; n.parent != nil; n = n.parent {
+		for _, sibling := range n.parent.children {
+			if sibling == n {
+				break
+			}
+			index += sibling.size
+		}
+	}
+	if n != tree.root {
+		return -1, ErrInvalidHandle
+	}
+	return index, nil
+}
+
+// adopt points the handles of the items of leaf to it.
+func adopt[T any](leaf *node[T]) {
+	for _, h := range leaf.handles {
+		if h != nil {
+			h.leaf = leaf
+		}
+	}
+}
+
+// drop invalidates the handles of deleted items.
+func drop[T any](handles []*Handle[T]) {
+	for _, h := range handles {
+		if h != nil {
+			h.leaf = nil
+		}
+	}
+}
+
+// dropSubtree invalidates the handles of all items below n.
+func dropSubtree[T any](n *node[T]) {
+	if n.isLeaf {
+		drop(n.handles)
+		return
+	}
+	for _, child := range n.children {
+		dropSubtree(child)
+	}
+}
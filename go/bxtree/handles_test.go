@@ -0,0 +1,116 @@
+package bxtree
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestHandles(t *testing.T) {
+	for _, s := range [][2]int{{1, 2}, {3, 2}, {LEAF_MIN_SIZE, INTERNAL_MIN_SIZE}} {
+		t.Run(fmt.Sprintf("leaf=%d/internal=%d", s[0], s[1]), func(t *testing.T) {
+			r := rand.New(rand.NewSource(11))
+			tree := New[int](WithLeafSize(s[0]), WithInternalSize(s[1]), WithHandles())
+			list := []int{}
+			handles := []*Handle[int]{} // handles[i] is the handle taken at list[i], or nil
+			deleted := []*Handle[int]{}
+			deleteHandles := func(from int, to int) {
+				for _, h := range handles[from:to] {
+					if h != nil {
+						deleted = append(deleted, h)
+					}
+				}
+				handles = slices.Delete(handles, from, to)
+			}
+
+			for i := 0; i < 3000; i++ {
+				index := r.Intn(len(list) + 1)
+				switch r.Intn(7) {
+				case 0, 1:
+					tree.InsertAt(index, i)
+					list = slices.Insert(list, index, i)
+					handles = slices.Insert(handles, index, nil)
+				case 2:
+					if index < len(list) {
+						tree.DeleteAt(index)
+						list = slices.Delete(list, index, index+1)
+						deleteHandles(index, index+1)
+					}
+				case 3:
+					items := make([]int, r.Intn(4*s[0]+10))
+					for j := range items {
+						items[j] = i*1000 + j
+					}
+					tree.InsertRange(index, items)
+					list = slices.Insert(list, index, items...)
+					handles = slices.Insert(handles, index, make([]*Handle[int], len(items))...)
+				case 4:
+					n := min(r.Intn(3*s[0]+5), len(list)-index)
+					tree.DeleteRange(index, n)
+					list = slices.Delete(list, index, index+n)
+					deleteHandles(index, index+n)
+				case 5:
+					// Move the right part through another tree and back
+					right, _ := tree.Split(index)
+					for j, h := range handles[index:] {
+						if h == nil {
+							continue
+						}
+						if at, err := right.IndexOf(h); err != nil || at != j {
+							t.Fatalf("Iteration %d: handle of %d in split tree at %d, %v", i, index+j, at, err)
+						}
+					}
+					tree.Concat(right)
+				default:
+					if index < len(list) {
+						handles[index], _ = tree.Handle(index)
+					}
+				}
+
+				if err := tree.Validate(); err != nil {
+					t.Fatalf("Iteration %d: %v", i, err)
+				}
+				for j, h := range handles {
+					if h == nil {
+						continue
+					}
+					if at, err := tree.IndexOf(h); err != nil || at != j {
+						t.Fatalf("Iteration %d: IndexOf gave %d, %v for the handle of %d", i, at, err, j)
+					}
+				}
+				for _, h := range deleted {
+					if _, err := tree.IndexOf(h); err != ErrInvalidHandle {
+						t.Fatalf("Iteration %d: expected ErrInvalidHandle for a deleted item, got %v", i, err)
+					}
+				}
+			}
+			checkTree(t, tree, list)
+		})
+	}
+}
+
+func TestHandleErrors(t *testing.T) {
+	if _, err := New[int]().Handle(0); err != ErrNoHandles {
+		t.Errorf("Expected ErrNoHandles, got %v", err)
+	}
+	tree, _ := FromSlice([]int{1, 2, 3}, 1, WithHandles())
+	if _, err := tree.Handle(3); err != ErrIndexOutOfBounds {
+		t.Errorf("Expected ErrIndexOutOfBounds, got %v", err)
+	}
+	h, _ := tree.Handle(1)
+	if again, _ := tree.Handle(1); again != h {
+		t.Errorf("Handle returned a new handle for the same item")
+	}
+	if _, err := New[int](WithHandles()).IndexOf(h); err != ErrInvalidHandle {
+		t.Errorf("Expected ErrInvalidHandle for another tree, got %v", err)
+	}
+	tree.ReplaceRange(0, 3, []int{4, 5, 6})
+	if at, err := tree.IndexOf(h); at != 1 || err != nil {
+		t.Errorf("Handle lost by ReplaceRange of the same length: %d, %v", at, err)
+	}
+	tree.ReplaceRange(0, 3, []int{7})
+	if _, err := tree.IndexOf(h); err != ErrInvalidHandle {
+		t.Errorf("Expected ErrInvalidHandle after ReplaceRange, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package bxtree
+
+import "iter"
+
+// All returns an iterator over the items in order.
+func (tree *BxTree[T]) All() iter.Seq[T] {
+	return tree.Range(0, tree.Size())
+}
+
+// Range returns an iterator over the items [from, to), clamped to the
+// tree. It descends once and then follows the leaf chain.
+func (tree *BxTree[T]) Range(from int, to int) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		from, to := max(from, 0), min(to, tree.Size())
+		if from >= to {
+			return
+		}
+		leaf, offset, _ := tree.getAt(from)
+		for remaining := to - from; leaf != nil; leaf, offset = leaf.next, 0 {
+			for _, item := range leaf.items[offset:min(len(leaf.items), offset+remaining)] {
+				if !yield(item) {
+					return
+				}
+				remaining--
+			}
+			if remaining == 0 {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the items in reverse order.
+func (tree *BxTree[T]) Backward() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for leaf := tree.last; leaf != nil; leaf = leaf.prev {
+			for i := len(leaf.items) - 1; i >= 0; i-- {
+				if !yield(leaf.items[i]) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Cursor is a position in a tree that steps through the items one at a
+// time without descending from the root. It is at an item, or just past
+// the last one. Any change to the tree invalidates it.
+type Cursor[T any] struct {
+	tree   *BxTree[T]
+	leaf   *node[T]
+	offset int
+	index  int
+}
+
+// Cursor returns a cursor at index, which may be Size() for the end.
+func (tree *BxTree[T]) Cursor(index int) (*Cursor[T], error) {
+	if index < 0 || index > tree.Size() {
+		return nil, ErrIndexOutOfBounds
+	}
+	cursor := &Cursor[T]{tree: tree, index: index}
+	if index == tree.Size() {
+		cursor.leaf = tree.last
+		if cursor.leaf != nil {
+			cursor.offset = cursor.leaf.size
+		}
+	} else {
+		cursor.leaf, cursor.offset, _ = tree.getAt(index)
+	}
+	return cursor, nil
+}
+
+func (c *Cursor[T]) Index() int {
+	return c.index
+}
+
+// Valid reports whether the cursor is at an item rather than at the end.
+func (c *Cursor[T]) Valid() bool {
+	return c.index < c.tree.Size()
+}
+
+// Item returns the item at the cursor. It must be Valid.
+func (c *Cursor[T]) Item() *T {
+	return &c.leaf.items[c.offset]
+}
+
+// Next moves to the next item, or to the end. It returns false if the
+// cursor was already at the end.
+func (c *Cursor[T]) Next() bool {
+	if !c.Valid() {
+		return false
+	}
+	c.index++
+	c.offset++
+	if c.offset == len(c.leaf.items) && c.leaf.next != nil {
+		c.leaf, c.offset = c.leaf.next, 0
+	}
+	return true
+}
+
+// Prev moves to the previous item. It returns false if the cursor was
+// already at the first one.
+func (c *Cursor[T]) Prev() bool {
+	if c.index == 0 {
+		return false
+	}
+	c.index--
+	c.offset--
+	if c.offset < 0 {
+		c.leaf = c.leaf.prev
+		c.offset = len(c.leaf.items) - 1
+	}
+	return true
+}
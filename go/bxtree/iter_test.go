@@ -0,0 +1,119 @@
+package bxtree
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestIterators(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	tree := New[int]()
+	list := []int{}
+
+	for i := 0; i < 2000; i++ {
+		switch index := r.Intn(len(list) + 1); {
+		case r.Float64() < 0.5:
+			tree.InsertAt(index, i)
+			list = slices.Insert(list, index, i)
+		case r.Float64() < 0.3 && index < len(list):
+			tree.DeleteAt(index)
+			list = slices.Delete(list, index, index+1)
+		case r.Float64() < 0.5:
+			items := make([]int, r.Intn(300))
+			for j := range items {
+				items[j] = r.Int()
+			}
+			tree.InsertRange(index, items)
+			list = slices.Insert(list, index, items...)
+		default:
+			length := r.Intn(min(len(list)-index, 200) + 1)
+			tree.DeleteRange(index, length)
+			list = slices.Delete(list, index, index+length)
+		}
+
+		if i%13 != 0 {
+			continue
+		}
+		if got := slices.Collect(tree.All()); !slices.Equal(got, list) {
+			t.Fatalf("Iteration %d: All does not match", i)
+		}
+		backward := slices.Collect(tree.Backward())
+		slices.Reverse(backward)
+		if !slices.Equal(backward, list) {
+			t.Fatalf("Iteration %d: Backward does not match", i)
+		}
+		from := r.Intn(len(list) + 1)
+		to := from + r.Intn(len(list)-from+1)
+		if got := slices.Collect(tree.Range(from, to)); !slices.Equal(got, list[from:to]) {
+			t.Fatalf("Iteration %d: Range(%d, %d) does not match", i, from, to)
+		}
+	}
+}
+
+func TestRangeClampsAndStops(t *testing.T) {
+	tree := New[int]()
+	for i := range 1000 {
+		tree.InsertAt(i, i)
+	}
+	if got := slices.Collect(tree.Range(-5, 3)); !slices.Equal(got, []int{0, 1, 2}) {
+		t.Errorf("Range(-5, 3) = %v", got)
+	}
+	if got := slices.Collect(tree.Range(998, 2000)); !slices.Equal(got, []int{998, 999}) {
+		t.Errorf("Range(998, 2000) = %v", got)
+	}
+	if got := slices.Collect(tree.Range(5, 5)); len(got) != 0 {
+		t.Errorf("Range(5, 5) = %v", got)
+	}
+	n := 0
+	for v := range tree.All() {
+		if v == 300 {
+			break
+		}
+		n++
+	}
+	if n != 300 {
+		t.Errorf("Expected to stop after 300 items, got %d", n)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	tree := New[int]()
+	items := make([]int, 1000)
+	for i := range items {
+		items[i] = i
+	}
+	tree.InsertRange(0, items)
+
+	c, err := tree.Cursor(500)
+	if err != nil {
+		t.Fatalf("Cursor(500) failed: %v", err)
+	}
+	for i := 500; i < 1000; i++ {
+		if !c.Valid() || *c.Item() != i || c.Index() != i {
+			t.Fatalf("Expected item %d going forward, got %d at %d", i, *c.Item(), c.Index())
+		}
+		if !c.Next() {
+			t.Fatalf("Next failed at %d", i)
+		}
+	}
+	if c.Valid() || c.Next() {
+		t.Fatalf("Expected the cursor to stop at the end")
+	}
+	for i := 999; i >= 0; i-- {
+		if !c.Prev() || *c.Item() != i {
+			t.Fatalf("Expected item %d going backward", i)
+		}
+	}
+	if c.Prev() || c.Index() != 0 {
+		t.Fatalf("Expected the cursor to stop at the start")
+	}
+
+	if _, err := tree.Cursor(1001); err != ErrIndexOutOfBounds {
+		t.Errorf("Expected ErrIndexOutOfBounds, got %v", err)
+	}
+	empty, _ := New[int]().Cursor(0)
+	if empty.Valid() || empty.Next() || empty.Prev() {
+		t.Errorf("Expected an empty cursor")
+	}
+}
@@ -0,0 +1,54 @@
+package bxtree
+
+// FromSlice builds a tree of items bottom-up in O(n). Leaves and internal
+// nodes are packed to about fill times their maximum size, but never below
+// the minimum: a low fill leaves room for inserts before nodes split, a
+// fill of 1 packs as tightly as possible for read-mostly trees.
+func FromSlice[T any](items []T, fill float64, opts ...Option) (*BxTree[T], error) {
+	if fill <= 0 || fill > 1 {
+		return nil, ErrInvalidFillFactor
+	}
+	c, measures, err := newConfig[T](opts)
+	if err != nil {
+		return nil, err
+	}
+	tree := &BxTree[T]{sizes: c.sizes, handles: c.handles, measures: measures}
+	if len(items) == 0 {
+		return tree, nil
+	}
+
+	parts := splitInto(items, packCount(len(items), fill, tree.leafMin, tree.leafMax()))
+	nodes := make([]*node[T], len(parts))
+	for i, part := range parts {
+		nodes[i] = &node[T]{isLeaf: true, items: part}
+		if tree.handles {
+			nodes[i].handles = make([]*Handle[T], len(part))
+		}
+		tree.refresh(nodes[i])
+		if i > 0 {
+			link(nodes[i-1], nodes[i])
+		}
+	}
+	for len(nodes) > 1 {
+		groups := splitInto(nodes, packCount(len(nodes), fill, tree.internalMin, tree.internalMax()))
+		nodes = make([]*node[T], len(groups))
+		for i, group := range groups {
+			nodes[i] = &node[T]{}
+			nodes[i].setChildren(group)
+			tree.refresh(nodes[i])
+		}
+	}
+	tree.setRoot(nodes[0])
+	return tree, nil
+}
+
+// packCount returns how many nodes to spread n entries over so that each
+// holds about fill*maxSize of them, staying within minSize and maxSize.
+func packCount(n int, fill float64, minSize int, maxSize int) int {
+	target := max(minSize, int(fill*float64(maxSize)))
+	count := max(1, n/target)
+	if (n+count-1)/count > maxSize {
+		count = (n + maxSize - 1) / maxSize
+	}
+	return count
+}
@@ -0,0 +1,45 @@
+package bxtree
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFromSlice(t *testing.T) {
+	for _, fill := range []float64{0.1, 0.5, 0.75, 1} {
+		for _, n := range []int{0, 1, 63, 64, 128, 129, 1000, 8191, 100_000} {
+			list := make([]int, n)
+			for i := range list {
+				list[i] = i * 7
+			}
+			tree, err := FromSlice(list, fill, WithMeasures(NewMeasure(func(v int) int { return v % 3 })))
+			if err != nil {
+				t.Fatalf("FromSlice(%d items, %v) failed: %v", n, fill, err)
+			}
+			checkTree(t, tree, list)
+
+			// The tree must stay usable for edits
+			tree.InsertAt(n/2, -1)
+			tree.DeleteRange(0, n/3)
+			list = slices.Insert(list, n/2, -1)[n/3:]
+			checkTree(t, tree, list)
+		}
+	}
+}
+
+func TestFromSliceFill(t *testing.T) {
+	list := make([]int, 128*100)
+	full, _ := FromSlice(list, 1)
+	if got := full.first.size; got != LEAF_MAX_SIZE {
+		t.Errorf("Expected full leaves of %d items, got %d", LEAF_MAX_SIZE, got)
+	}
+	half, _ := FromSlice(list, 0.5)
+	if got := half.first.size; got != LEAF_MIN_SIZE {
+		t.Errorf("Expected half-full leaves of %d items, got %d", LEAF_MIN_SIZE, got)
+	}
+	for _, fill := range []float64{0, -1, 1.5} {
+		if _, err := FromSlice(list, fill); err != ErrInvalidFillFactor {
+			t.Errorf("Expected ErrInvalidFillFactor for fill %v, got %v", fill, err)
+		}
+	}
+}
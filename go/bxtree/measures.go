@@ -0,0 +1,152 @@
+package bxtree
+
+// Measures are per-item integer summaries (e.g. the UTF-16 length of a
+// rune, or whether a CRDT item is visible) summed per subtree alongside
+// size, so that positions can be converted between indices and any
+// measure in O(log n). Sums are kept up to date by recomputing every node
+// an operation touched, which covers splits, merges and borrows alike.
+
+// Measure gives the value of one item. Values must not be negative for
+// SeekBy to work.
+type Measure[T any] struct {
+	of func(T) int
+}
+
+func NewMeasure[T any](of func(T) int) *Measure[T] {
+	return &Measure[T]{of: of}
+}
+
+// NewMeasured creates a tree that maintains the sum of each measure, with
+// the default node sizes.
+func NewMeasured[T any](measures ...*Measure[T]) *BxTree[T] {
+	return New[T](WithMeasures(measures...))
+}
+
+func (tree *BxTree[T]) measureIndex(m *Measure[T]) (int, error) {
+	for i, measure := range tree.measures {
+		if measure == m {
+			return i, nil
+		}
+	}
+	return -1, ErrUnknownMeasure
+}
+
+// touch marks a node whose items or children changed.
+func (tree *BxTree[T]) touch(n *node[T]) {
+	if len(tree.measures) > 0 {
+		tree.touched = append(tree.touched, n)
+	}
+}
+
+// fixSums recomputes the touched nodes and their ancestors. Every node is
+// recomputed for the last time after all its touched descendants, since
+// those are only recomputed on walks that pass through it afterwards.
+func (tree *BxTree[T]) fixSums() {
+	for _, n := range tree.touched {
+		for p := n; p != nil; p = p.parent {
+			tree.recompute(p)
+		}
+	}
+	tree.touched = tree.touched[:0]
+}
+
+func (tree *BxTree[T]) recompute(n *node[T]) {
+	if n.sums == nil {
+		n.sums = make([]int, len(tree.measures))
+	}
+	for m, measure := range tree.measures {
+		sum := 0
+		if n.isLeaf {
+			for _, item := range n.items {
+				sum += measure.of(item)
+			}
+		} else {
+			for _, child := range n.children {
+				// New nodes get their sums later in the same fix
+				if child.sums != nil {
+					sum += child.sums[m]
+				}
+			}
+		}
+		n.sums[m] = sum
+	}
+}
+
+// Sum returns the sum of m over the whole tree. m must be one of the
+// measures of the tree.
+func (tree *BxTree[T]) Sum(m *Measure[T]) int {
+	i, err := tree.measureIndex(m)
+	if err != nil {
+		panic(err)
+	}
+	if tree.root == nil {
+		return 0
+	}
+	return tree.root.sums[i]
+}
+
+// PrefixSum returns the sum of m over the items before index.
+func (tree *BxTree[T]) PrefixSum(m *Measure[T], index int) (int, error) {
+	i, err := tree.measureIndex(m)
+	if err != nil {
+		return 0, err
+	}
+	if index < 0 || index > tree.Size() {
+		return 0, ErrIndexOutOfBounds
+	}
+	if index == tree.Size() {
+		return tree.Sum(m), nil
+	}
+	sum := 0
+	node := tree.root
+	for !node.isLeaf {
+		for _, child := range node.children {
+			if index < child.size {
+				node = child
+				break
+			}
+			index -= child.size
+			sum += child.sums[i]
+		}
+	}
+	for _, item := range node.items[:index] {
+		sum += m.of(item)
+	}
+	return sum, nil
+}
+
+// SeekBy returns the index of the item that contains offset target of m,
+// and the offset within that item. Items where m is 0 are skipped over. A
+// target equal to the total maps to (Size(), 0).
+func (tree *BxTree[T]) SeekBy(m *Measure[T], target int) (int, int, error) {
+	i, err := tree.measureIndex(m)
+	if err != nil {
+		return -1, 0, err
+	}
+	if target < 0 || target > tree.Sum(m) {
+		return -1, 0, ErrIndexOutOfBounds
+	}
+	if target == tree.Sum(m) {
+		return tree.Size(), 0, nil
+	}
+	index := 0
+	node := tree.root
+	for !node.isLeaf {
+		for _, child := range node.children {
+			if target < child.sums[i] {
+				node = child
+				break
+			}
+			target -= child.sums[i]
+			index += child.size
+		}
+	}
+	for j, item := range node.items {
+		value := m.of(item)
+		if target < value {
+			return index + j, target, nil
+		}
+		target -= value
+	}
+	return -1, 0, ErrIndexOutOfBounds
+}
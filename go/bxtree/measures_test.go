@@ -0,0 +1,91 @@
+package bxtree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMeasuresRandomOps(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	value := NewMeasure(func(v int) int { return v })
+	even := NewMeasure(func(v int) int { return 1 - v%2 })
+	tree := NewMeasured(value, even)
+	list := []int{}
+
+	for i := range 20000 {
+		if len(list) == 0 || r.Float64() < 0.55 {
+			index := r.Intn(len(list) + 1)
+			v := r.Intn(4)
+			if err := tree.InsertAt(index, v); err != nil {
+				t.Fatalf("InsertAt failed: %v", err)
+			}
+			list = append(list[:index], append([]int{v}, list[index:]...)...)
+		} else {
+			index := r.Intn(len(list))
+			if err := tree.DeleteAt(index); err != nil {
+				t.Fatalf("DeleteAt failed: %v", err)
+			}
+			list = append(list[:index], list[index+1:]...)
+		}
+
+		if i%97 != 0 {
+			continue
+		}
+		sum, evens := 0, 0
+		for j, v := range list {
+			got, _ := tree.PrefixSum(value, j)
+			if got != sum {
+				t.Fatalf("Iteration %d: PrefixSum(value, %d) = %d, expected %d", i, j, got, sum)
+			}
+			for k := range v {
+				index, offset, err := tree.SeekBy(value, sum+k)
+				if err != nil || index != j || offset != k {
+					t.Fatalf("Iteration %d: SeekBy(value, %d) = %d, %d, %v, expected %d, %d", i, sum+k, index, offset, err, j, k)
+				}
+			}
+			sum += v
+			evens += 1 - v%2
+		}
+		if tree.Sum(value) != sum || tree.Sum(even) != evens {
+			t.Fatalf("Iteration %d: sums %d, %d, expected %d, %d", i, tree.Sum(value), tree.Sum(even), sum, evens)
+		}
+		if index, _, _ := tree.SeekBy(value, sum); index != len(list) {
+			t.Fatalf("Iteration %d: SeekBy at the end returned %d", i, index)
+		}
+	}
+}
+
+func TestSeekByVisible(t *testing.T) {
+	type item struct {
+		id      int
+		deleted bool
+	}
+	visible := NewMeasure(func(it item) int {
+		if it.deleted {
+			return 0
+		}
+		return 1
+	})
+	tree := NewMeasured(visible)
+	for i := range 500 {
+		tree.InsertAt(i, item{id: i, deleted: i%3 != 0})
+	}
+	// Only every third item is visible
+	for n := range 167 {
+		index, offset, err := tree.SeekBy(visible, n)
+		if err != nil || index != 3*n || offset != 0 {
+			t.Fatalf("SeekBy(visible, %d) = %d, %d, %v, expected %d", n, index, offset, err, 3*n)
+		}
+	}
+}
+
+func TestUnknownMeasure(t *testing.T) {
+	tree := NewMeasured(NewMeasure(func(v int) int { return v }))
+	other := NewMeasure(func(v int) int { return v })
+	if _, err := tree.PrefixSum(other, 0); err != ErrUnknownMeasure {
+		t.Errorf("Expected ErrUnknownMeasure from PrefixSum, got %v", err)
+	}
+	if _, _, err := tree.SeekBy(other, 0); err != ErrUnknownMeasure {
+		t.Errorf("Expected ErrUnknownMeasure from SeekBy, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package bxtree
+
+import "fmt"
+
+// Option configures a tree at construction.
+type Option func(*config)
+
+type config struct {
+	sizes
+	handles  bool
+	measures any // []*Measure[T] for the item type T of the tree
+}
+
+// sizes bounds how full nodes are: leaves hold leafMin to 2*leafMin items
+// and internal nodes internalMin to 2*internalMin children, except for the
+// root. Small leaves make edits cheaper, large ones make reads and scans
+// cheaper and waste less memory on nodes when items are small.
+type sizes struct {
+	leafMin     int
+	internalMin int
+}
+
+func (s sizes) leafMax() int {
+	return 2 * s.leafMin
+}
+
+func (s sizes) internalMax() int {
+	return 2 * s.internalMin
+}
+
+// WithLeafSize sets the minimum number of items of a leaf; the maximum is
+// twice that. It defaults to LEAF_MIN_SIZE.
+func WithLeafSize(min int) Option {
+	return func(c *config) {
+		c.leafMin = min
+	}
+}
+
+// WithInternalSize sets the minimum number of children of an internal
+// node; the maximum is twice that. It defaults to INTERNAL_MIN_SIZE.
+func WithInternalSize(min int) Option {
+	return func(c *config) {
+		c.internalMin = min
+	}
+}
+
+// WithMeasures makes the tree maintain the sum of each measure.
+func WithMeasures[T any](measures ...*Measure[T]) Option {
+	return func(c *config) {
+		c.measures = measures
+	}
+}
+
+// WithHandles makes leaves keep track of the handles on their items, so
+// that Handle and IndexOf can be used.
+func WithHandles() Option {
+	return func(c *config) {
+		c.handles = true
+	}
+}
+
+func newConfig[T any](opts []Option) (config, []*Measure[T], error) {
+	c := config{sizes: sizes{leafMin: LEAF_MIN_SIZE, internalMin: INTERNAL_MIN_SIZE}}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.leafMin < 1 {
+		return config{}, nil, fmt.Errorf("%w: leaves need at least 1 item, got %d", ErrInvalidOption, c.leafMin)
+	}
+	if c.internalMin < 2 {
+		return config{}, nil, fmt.Errorf("%w: internal nodes need at least 2 children, got %d", ErrInvalidOption, c.internalMin)
+	}
+	var measures []*Measure[T]
+	if c.measures != nil {
+		var ok bool
+		if measures, ok = c.measures.([]*Measure[T]); !ok {
+			return config{}, nil, fmt.Errorf("%w: measures of %T for a tree of %T", ErrInvalidOption, c.measures, *new(T))
+		}
+	}
+	return c, measures, nil
+}
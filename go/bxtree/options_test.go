@@ -0,0 +1,67 @@
+package bxtree
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestNodeSizes(t *testing.T) {
+	for _, s := range [][2]int{{1, 2}, {2, 3}, {4, 2}, {16, 4}, {256, 64}} {
+		t.Run(fmt.Sprintf("leaf=%d/internal=%d", s[0], s[1]), func(t *testing.T) {
+			r := rand.New(rand.NewSource(6))
+			tree := New[int](WithLeafSize(s[0]), WithInternalSize(s[1]), WithMeasures(NewMeasure(func(v int) int { return v % 3 })))
+			list := []int{}
+			for i := 0; i < 1500; i++ {
+				index := r.Intn(len(list) + 1)
+				switch r.Intn(4) {
+				case 0:
+					tree.InsertAt(index, i)
+					list = slices.Insert(list, index, i)
+				case 1:
+					items := make([]int, r.Intn(200))
+					for j := range items {
+						items[j] = r.Int()
+					}
+					tree.InsertRange(index, items)
+					list = slices.Insert(list, index, items...)
+				case 2:
+					length := r.Intn(min(len(list)-index, 100) + 1)
+					tree.DeleteRange(index, length)
+					list = slices.Delete(list, index, index+length)
+				default:
+					right, _ := tree.Split(index)
+					other, _ := FromSlice([]int{-1, -2, -3}, 0.5, WithLeafSize(s[0]), WithInternalSize(s[1]), WithMeasures(tree.measures...))
+					tree.Concat(other)
+					tree.Concat(right)
+					list = slices.Insert(list, index, -1, -2, -3)
+				}
+				if i%7 == 0 {
+					checkTree(t, tree, list)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithLeafSize(0)},
+		{WithInternalSize(1)},
+		{WithMeasures(NewMeasure(func(s string) int { return len(s) }))},
+	} {
+		if _, err := FromSlice([]int{1}, 1, opts...); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("Expected ErrInvalidOption, got %v", err)
+		}
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, ErrInvalidOption) {
+					t.Errorf("Expected New to panic with ErrInvalidOption, got %v", err)
+				}
+			}()
+			New[int](opts...)
+		}()
+	}
+}
@@ -0,0 +1,415 @@
+package bxtree
+
+import (
+	"iter"
+	"slices"
+)
+
+// Persistent is an immutable tree: every edit copies the nodes on the path
+// it touches and returns a new version sharing all other nodes with the
+// old one. Versions are safe to read from any number of goroutines while
+// others keep editing.
+//
+// Its nodes have no parent pointers or leaf chain, since a node can be in
+// many versions at once; that is why it is a separate type from BxTree.
+type Persistent[T any] struct {
+	root *pnode[T]
+	sizes
+	measures []*Measure[T]
+}
+
+type pnode[T any] struct {
+	size     int
+	items    []T         // only for leaf nodes
+	children []*pnode[T] // only for internal nodes
+	sums     []int
+}
+
+// NewPersistent creates an empty version. It panics if the options are
+// invalid.
+func NewPersistent[T any](opts ...Option) *Persistent[T] {
+	c, measures, err := newConfig[T](opts)
+	if err != nil {
+		panic(err)
+	}
+	return &Persistent[T]{sizes: c.sizes, measures: measures}
+}
+
+// Persistent returns the content of the tree as a persistent version. It
+// copies every node, so it takes O(n) time: convert once and then edit the
+// persistent versions, which share all untouched nodes.
+func (tree *BxTree[T]) Persistent() *Persistent[T] {
+	p := &Persistent[T]{sizes: tree.sizes, measures: tree.measures}
+	if tree.root != nil {
+		p.root = p.copyNode(tree.root)
+	}
+	return p
+}
+
+func (p *Persistent[T]) copyNode(n *node[T]) *pnode[T] {
+	if n.isLeaf {
+		return p.leaf(slices.Clone(n.items))
+	}
+	children := make([]*pnode[T], len(n.children))
+	for i, child := range n.children {
+		children[i] = p.copyNode(child)
+	}
+	return p.internal(children)
+}
+
+func (p *Persistent[T]) leaf(items []T) *pnode[T] {
+	n := &pnode[T]{size: len(items), items: items, sums: make([]int, len(p.measures))}
+	for m, measure := range p.measures {
+		for _, item := range items {
+			n.sums[m] += measure.of(item)
+		}
+	}
+	return n
+}
+
+func (p *Persistent[T]) internal(children []*pnode[T]) *pnode[T] {
+	n := &pnode[T]{children: children, sums: make([]int, len(p.measures))}
+	for _, child := range children {
+		n.size += child.size
+		for m := range p.measures {
+			n.sums[m] += child.sums[m]
+		}
+	}
+	return n
+}
+
+// version returns a version with root and the configuration of p.
+func (p *Persistent[T]) version(root *pnode[T]) *Persistent[T] {
+	return &Persistent[T]{root: root, sizes: p.sizes, measures: p.measures}
+}
+
+func (n *pnode[T]) isLeaf() bool {
+	return n.children == nil
+}
+
+func (p *Persistent[T]) underfull(n *pnode[T]) bool {
+	if n.isLeaf() {
+		return len(n.items) < p.leafMin
+	}
+	return len(n.children) < p.internalMin
+}
+
+// child returns the index of the child holding index, and the index within
+// it.
+func (n *pnode[T]) child(index int) (int, int) {
+	for i, child := range n.children {
+		if index < child.size {
+			return i, index
+		}
+		index -= child.size
+	}
+	return len(n.children) - 1, index + n.children[len(n.children)-1].size
+}
+
+func (p *Persistent[T]) Size() int {
+	if p.root == nil {
+		return 0
+	}
+	return p.root.size
+}
+
+func (p *Persistent[T]) GetAt(index int) (T, error) {
+	if index < 0 || index >= p.Size() {
+		return *new(T), ErrIndexOutOfBounds
+	}
+	n := p.root
+	for !n.isLeaf() {
+		var i int
+		i, index = n.child(index)
+		n = n.children[i]
+	}
+	return n.items[index], nil
+}
+
+func (p *Persistent[T]) measureIndex(m *Measure[T]) (int, error) {
+	if i := slices.Index(p.measures, m); i != -1 {
+		return i, nil
+	}
+	return -1, ErrUnknownMeasure
+}
+
+// All returns an iterator over the items of this version in order.
+func (p *Persistent[T]) All() iter.Seq[T] {
+	return p.Range(0, p.Size())
+}
+
+// Range returns an iterator over the items [from, to) of this version,
+// clamped to its size.
+func (p *Persistent[T]) Range(from int, to int) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		from, to := max(from, 0), min(to, p.Size())
+		if from < to {
+			p.root.rangeOf(from, to, yield)
+		}
+	}
+}
+
+func (n *pnode[T]) rangeOf(from int, to int, yield func(T) bool) bool {
+	if n.isLeaf() {
+		for _, item := range n.items[from:to] {
+			if !yield(item) {
+				return false
+			}
+		}
+		return true
+	}
+	start := 0
+	for _, child := range n.children {
+		end := start + child.size
+		if start < to && end > from {
+			if !child.rangeOf(max(from-start, 0), min(to, end)-start, yield) {
+				return false
+			}
+		}
+		if end >= to {
+			return true
+		}
+		start = end
+	}
+	return true
+}
+
+// Sum returns the sum of m over this version. m must be one of the
+// measures of the tree.
+func (p *Persistent[T]) Sum(m *Measure[T]) int {
+	i, err := p.measureIndex(m)
+	if err != nil {
+		panic(err)
+	}
+	if p.root == nil {
+		return 0
+	}
+	return p.root.sums[i]
+}
+
+// PrefixSum returns the sum of m over the items before index.
+func (p *Persistent[T]) PrefixSum(m *Measure[T], index int) (int, error) {
+	i, err := p.measureIndex(m)
+	if err != nil {
+		return 0, err
+	}
+	if index < 0 || index > p.Size() {
+		return 0, ErrIndexOutOfBounds
+	}
+	if index == p.Size() {
+		return p.Sum(m), nil
+	}
+	sum := 0
+	n := p.root
+	for !n.isLeaf() {
+		for _, child := range n.children {
+			if index < child.size {
+				n = child
+				break
+			}
+			index -= child.size
+			sum += child.sums[i]
+		}
+	}
+	for _, item := range n.items[:index] {
+		sum += m.of(item)
+	}
+	return sum, nil
+}
+
+// SeekBy returns the index of the item that contains offset target of m,
+// and the offset within that item, like BxTree.SeekBy.
+func (p *Persistent[T]) SeekBy(m *Measure[T], target int) (int, int, error) {
+	i, err := p.measureIndex(m)
+	if err != nil {
+		return -1, 0, err
+	}
+	if target < 0 || target > p.Sum(m) {
+		return -1, 0, ErrIndexOutOfBounds
+	}
+	if target == p.Sum(m) {
+		return p.Size(), 0, nil
+	}
+	index := 0
+	n := p.root
+	for !n.isLeaf() {
+		for _, child := range n.children {
+			if target < child.sums[i] {
+				n = child
+				break
+			}
+			target -= child.sums[i]
+			index += child.size
+		}
+	}
+	for j, item := range n.items {
+		value := m.of(item)
+		if target < value {
+			return index + j, target, nil
+		}
+		target -= value
+	}
+	return -1, 0, ErrIndexOutOfBounds
+}
+
+// InsertAt returns a new version with item inserted at index.
+func (p *Persistent[T]) InsertAt(index int, item T) (*Persistent[T], error) {
+	return p.InsertRange(index, []T{item})
+}
+
+// InsertRange returns a new version with items inserted at index. Only the
+// nodes on the path to index are copied.
+func (p *Persistent[T]) InsertRange(index int, items []T) (*Persistent[T], error) {
+	if index < 0 || index > p.Size() {
+		return nil, ErrIndexOutOfBounds
+	}
+	if len(items) == 0 {
+		return p, nil
+	}
+	var nodes []*pnode[T]
+	if p.root == nil {
+		nodes = p.leaves(slices.Clone(items))
+	} else {
+		nodes = p.insertRange(p.root, index, items)
+	}
+	for len(nodes) > 1 {
+		nodes = p.internals(nodes)
+	}
+	return p.version(nodes[0]), nil
+}
+
+// insertRange returns the nodes that replace n once items are inserted
+// into it: one copy of n, or several if it had to be split.
+func (p *Persistent[T]) insertRange(n *pnode[T], index int, items []T) []*pnode[T] {
+	if n.isLeaf() {
+		return p.leaves(slices.Concat(n.items[:index], items, n.items[index:]))
+	}
+	i, index := n.child(index)
+	children := slices.Clone(n.children)
+	children = slices.Replace(children, i, i+1, p.insertRange(n.children[i], index, items)...)
+	return p.internals(children)
+}
+
+// leaves cuts items into as few leaves as fit them.
+func (p *Persistent[T]) leaves(items []T) []*pnode[T] {
+	if len(items) <= p.leafMax() {
+		return []*pnode[T]{p.leaf(items)}
+	}
+	parts := splitEven(items, p.leafMax())
+	nodes := make([]*pnode[T], len(parts))
+	for i, part := range parts {
+		nodes[i] = p.leaf(part)
+	}
+	return nodes
+}
+
+// internals groups children into as few internal nodes as fit them.
+func (p *Persistent[T]) internals(children []*pnode[T]) []*pnode[T] {
+	if len(children) <= p.internalMax() {
+		return []*pnode[T]{p.internal(children)}
+	}
+	parts := splitEven(children, p.internalMax())
+	nodes := make([]*pnode[T], len(parts))
+	for i, part := range parts {
+		nodes[i] = p.internal(part)
+	}
+	return nodes
+}
+
+// DeleteAt returns a new version without the item at index.
+func (p *Persistent[T]) DeleteAt(index int) (*Persistent[T], error) {
+	if index < 0 || index >= p.Size() {
+		return nil, ErrIndexOutOfBounds
+	}
+	return p.DeleteRange(index, 1)
+}
+
+// DeleteRange returns a new version without the length items starting at
+// index. Subtrees inside the range are left out whole, and only the nodes
+// along its two edges are copied.
+func (p *Persistent[T]) DeleteRange(index int, length int) (*Persistent[T], error) {
+	if index < 0 || length < 0 || index+length > p.Size() {
+		return nil, ErrIndexOutOfBounds
+	}
+	if length == 0 {
+		return p, nil
+	}
+	if length == p.Size() {
+		return p.version(nil), nil
+	}
+	root := p.deleteRange(p.root, index, index+length)
+	for !root.isLeaf() && len(root.children) == 1 {
+		root = root.children[0]
+	}
+	return p.version(root), nil
+}
+
+// deleteRange returns a copy of n without the items [from, to). The copy
+// may be underfull for the caller to fix.
+func (p *Persistent[T]) deleteRange(n *pnode[T], from int, to int) *pnode[T] {
+	if n.isLeaf() {
+		return p.leaf(slices.Delete(slices.Clone(n.items), from, to))
+	}
+	kept := make([]*pnode[T], 0, len(n.children))
+	partial := []int{}
+	start := 0
+	for _, child := range n.children {
+		end := start + child.size
+		switch {
+		case end <= from || start >= to:
+			kept = append(kept, child)
+		case from <= start && end <= to:
+			// Left out with its whole subtree
+		default:
+			kept = append(kept, p.deleteRange(child, max(from-start, 0), min(to, end)-start))
+			partial = append(partial, len(kept)-1)
+		}
+		start = end
+	}
+	if len(partial) == 2 {
+		// The two edges of the range are now next to each other
+		kept = slices.Replace(kept, partial[0], partial[1]+1, p.join(kept[partial[0]], kept[partial[1]])...)
+	}
+	if len(partial) > 0 {
+		kept = p.fixAt(kept, partial[0])
+	}
+	return p.internal(kept)
+}
+
+// join merges two neighbouring nodes of the same height, either of which
+// may be underfull along their shared edge, into one or two new nodes that
+// are not (unless everything fits in one underfull node).
+func (p *Persistent[T]) join(left *pnode[T], right *pnode[T]) []*pnode[T] {
+	if left.isLeaf() {
+		items := slices.Concat(left.items, right.items)
+		if len(items) <= p.leafMax() {
+			return []*pnode[T]{p.leaf(items)}
+		}
+		parts := splitInto(items, 2)
+		return []*pnode[T]{p.leaf(parts[0]), p.leaf(parts[1])}
+	}
+
+	edge := len(left.children)
+	children := slices.Concat(left.children, right.children)
+	if p.underfull(children[edge-1]) || p.underfull(children[edge]) {
+		children = slices.Replace(children, edge-1, edge+1, p.join(children[edge-1], children[edge])...)
+		children = p.fixAt(children, edge-1)
+	}
+	if len(children) <= p.internalMax() {
+		return []*pnode[T]{p.internal(children)}
+	}
+	parts := splitInto(children, 2)
+	return []*pnode[T]{p.internal(parts[0]), p.internal(parts[1])}
+}
+
+// fixAt joins the node at index of siblings with its neighbours until it is
+// no longer underfull or it is the only one left.
+func (p *Persistent[T]) fixAt(siblings []*pnode[T], index int) []*pnode[T] {
+	for len(siblings) > 1 && p.underfull(siblings[index]) {
+		if index > 0 {
+			index--
+		}
+		siblings = slices.Replace(siblings, index, index+2, p.join(siblings[index], siblings[index+1])...)
+	}
+	return siblings
+}
@@ -0,0 +1,210 @@
+package bxtree
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestPersistentVersions(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	measure := NewMeasure(func(v int) int { return v % 3 })
+	version := NewPersistent[int](WithMeasures(measure))
+	list := []int{}
+	versions := []*Persistent[int]{version}
+	lists := [][]int{list}
+
+	for i := 0; i < 20000; i++ {
+		var err error
+		if len(list) == 0 || r.Float64() < 0.6 {
+			index := r.Intn(len(list) + 1)
+			version, err = version.InsertAt(index, i)
+			list = slices.Insert(slices.Clone(list), index, i)
+		} else {
+			index := r.Intn(len(list))
+			version, err = version.DeleteAt(index)
+			list = slices.Delete(slices.Clone(list), index, index+1)
+		}
+		if err != nil {
+			t.Fatalf("Iteration %d failed: %v", i, err)
+		}
+		if i%500 == 0 {
+			versions = append(versions, version)
+			lists = append(lists, list)
+		}
+	}
+
+	// Every version still has the content it had when it was made
+	for i, v := range versions {
+		if got := slices.Collect(v.All()); !slices.Equal(got, lists[i]) {
+			t.Fatalf("Version %d changed", i)
+		}
+		sum := 0
+		for j, item := range lists[i] {
+			if got, _ := v.GetAt(j); got != item {
+				t.Fatalf("Version %d: GetAt(%d) = %d, expected %d", i, j, got, item)
+			}
+			sum += item % 3
+		}
+		if v.Sum(measure) != sum {
+			t.Fatalf("Version %d: Sum = %d, expected %d", i, v.Sum(measure), sum)
+		}
+	}
+}
+
+func TestPersistentFromTree(t *testing.T) {
+	list := make([]int, 5000)
+	for i := range list {
+		list[i] = i
+	}
+	tree, _ := FromSlice(list, 1)
+	snapshot := tree.Persistent()
+	tree.DeleteRange(0, 2500)
+
+	if got := slices.Collect(snapshot.All()); !slices.Equal(got, list) {
+		t.Errorf("Snapshot follows edits of the tree")
+	}
+	if _, err := snapshot.DeleteAt(5000); err != ErrIndexOutOfBounds {
+		t.Errorf("Expected ErrIndexOutOfBounds, got %v", err)
+	}
+}
+
+func TestPersistentConcurrentReaders(t *testing.T) {
+	version := NewPersistent[int]()
+	for i := range 2000 {
+		version, _ = version.InsertAt(i, i)
+	}
+	snapshot := version
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				i := 0
+				for item := range snapshot.All() {
+					if item != i {
+						t.Errorf("Reader saw %d at %d", item, i)
+						return
+					}
+					i++
+				}
+			}
+		}()
+	}
+	for i := range 2000 {
+		version, _ = version.DeleteAt(0)
+		version, _ = version.InsertAt(version.Size(), -i)
+	}
+	wg.Wait()
+}
+
+func TestPersistentRanges(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	measure := NewMeasure(func(v int) int { return v % 3 })
+	opts := []Option{WithLeafSize(4), WithInternalSize(2), WithMeasures(measure)}
+	version := NewPersistent[int](opts...)
+	list := []int{}
+	versions := []*Persistent[int]{version}
+	lists := [][]int{list}
+
+	next := 0
+	for i := 0; i < 3000; i++ {
+		var err error
+		if len(list) == 0 || r.Float64() < 0.55 {
+			index := r.Intn(len(list) + 1)
+			items := make([]int, r.Intn(40))
+			for j := range items {
+				items[j] = next
+				next++
+			}
+			version, err = version.InsertRange(index, items)
+			list = slices.Insert(slices.Clone(list), index, items...)
+		} else {
+			index := r.Intn(len(list))
+			length := r.Intn(min(len(list)-index, 60) + 1)
+			version, err = version.DeleteRange(index, length)
+			list = slices.Delete(slices.Clone(list), index, index+length)
+		}
+		if err != nil {
+			t.Fatalf("Iteration %d failed: %v", i, err)
+		}
+		if _, err := checkPersistent(version, version.root, true); err != nil {
+			t.Fatalf("Iteration %d: %v", i, err)
+		}
+		if i%100 == 0 {
+			versions = append(versions, version)
+			lists = append(lists, list)
+		}
+	}
+
+	tree, _ := FromSlice(list, 1, WithMeasures(measure))
+	for i, v := range versions {
+		if got := slices.Collect(v.All()); !slices.Equal(got, lists[i]) {
+			t.Fatalf("Version %d changed", i)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		from := r.Intn(len(list) + 1)
+		to := from + r.Intn(len(list)-from+1)
+		if got := slices.Collect(version.Range(from, to)); !slices.Equal(got, list[from:to]) {
+			t.Fatalf("Range(%d, %d) = %v, expected %v", from, to, got, list[from:to])
+		}
+		got, _ := version.PrefixSum(measure, from)
+		expected, _ := tree.PrefixSum(measure, from)
+		if got != expected {
+			t.Fatalf("PrefixSum(%d) = %d, expected %d", from, got, expected)
+		}
+		target := r.Intn(version.Sum(measure) + 1)
+		index, offset, _ := version.SeekBy(measure, target)
+		expectedIndex, expectedOffset, _ := tree.SeekBy(measure, target)
+		if index != expectedIndex || offset != expectedOffset {
+			t.Fatalf("SeekBy(%d) = (%d, %d), expected (%d, %d)", target, index, offset, expectedIndex, expectedOffset)
+		}
+	}
+	if _, err := version.DeleteRange(0, version.Size()+1); err != ErrIndexOutOfBounds {
+		t.Errorf("Expected ErrIndexOutOfBounds, got %v", err)
+	}
+	if _, err := version.PrefixSum(NewMeasure(func(int) int { return 1 }), 0); err != ErrUnknownMeasure {
+		t.Errorf("Expected ErrUnknownMeasure, got %v", err)
+	}
+}
+
+// checkPersistent checks the sizes, sums and fill of the subtree n and that
+// all its leaves are as deep, and returns its height.
+func checkPersistent[T any](p *Persistent[T], n *pnode[T], root bool) (int, error) {
+	if n == nil {
+		return 0, nil
+	}
+	if !root && p.underfull(n) {
+		return 0, fmt.Errorf("underfull node of size %d", n.size)
+	}
+	expected := p.leaf(n.items)
+	height := 0
+	if !n.isLeaf() {
+		if len(n.children) > p.internalMax() {
+			return 0, fmt.Errorf("internal node with %d children", len(n.children))
+		}
+		for i, child := range n.children {
+			h, err := checkPersistent(p, child, false)
+			if err != nil {
+				return 0, err
+			}
+			if i > 0 && h != height {
+				return 0, fmt.Errorf("leaves at different depths")
+			}
+			height = h
+		}
+		expected = p.internal(n.children)
+		height++
+	} else if len(n.items) > p.leafMax() {
+		return 0, fmt.Errorf("leaf with %d items", len(n.items))
+	}
+	if n.size != expected.size || !slices.Equal(n.sums, expected.sums) {
+		return 0, fmt.Errorf("stale size or sums")
+	}
+	return height, nil
+}
@@ -0,0 +1,140 @@
+package bxtree
+
+import "slices"
+
+// Split cuts the tree at index: the tree keeps the items before index and
+// the items from index on are returned as a new tree with the same
+// measures. Only the nodes on the path to index are cut and rebalanced,
+// so it runs in O(log n).
+func (tree *BxTree[T]) Split(index int) (*BxTree[T], error) {
+	if index < 0 || index > tree.Size() {
+		return nil, ErrIndexOutOfBounds
+	}
+	right := &BxTree[T]{sizes: tree.sizes, handles: tree.handles, measures: tree.measures}
+	if tree.root == nil {
+		return right, nil
+	}
+	tree.root, right.root = tree.splitNode(tree.root, index)
+	tree.setRoot(tree.root)
+	right.setRoot(right.root)
+	return right, nil
+}
+
+// splitNode cuts the subtree n into the items before index, which stay in
+// n, and the items from index on. Either side is nil when it is empty. The
+// nodes along the cut may be left underfull for the caller to fix.
+func (tree *BxTree[T]) splitNode(n *node[T], index int) (*node[T], *node[T]) {
+	if index == 0 {
+		return nil, n
+	}
+	if index == n.size {
+		return n, nil
+	}
+	if n.isLeaf {
+		right := &node[T]{isLeaf: true, items: slices.Clone(n.items[index:])}
+		n.items = n.items[:index]
+		if tree.handles {
+			right.handles = slices.Clone(n.handles[index:])
+			n.handles = n.handles[:index]
+			adopt(right)
+		}
+		link(right, n.next)
+		link(n, right)
+		tree.refresh(n)
+		tree.refresh(right)
+		return n, right
+	}
+
+	i := 0
+	for index >= n.children[i].size {
+		index -= n.children[i].size
+		i++
+	}
+	cutLeft, cutRight := tree.splitNode(n.children[i], index)
+	left := slices.Clone(n.children[:i])
+	right := slices.Clone(n.children[i+1:])
+	if cutLeft != nil {
+		left = tree.fixAt(append(left, cutLeft), i)
+	}
+	if cutRight != nil {
+		right = tree.fixAt(append([]*node[T]{cutRight}, right...), 0)
+	}
+
+	var leftNode, rightNode *node[T]
+	if len(left) > 0 {
+		leftNode = n
+		leftNode.setChildren(left)
+		tree.refresh(leftNode)
+	}
+	if len(right) > 0 {
+		rightNode = &node[T]{}
+		rightNode.setChildren(right)
+		tree.refresh(rightNode)
+	}
+	return leftNode, rightNode
+}
+
+// setRoot makes n the root, dropping roots with a single child, and finds
+// first and last again.
+func (tree *BxTree[T]) setRoot(n *node[T]) {
+	for n != nil && !n.isLeaf && len(n.children) == 1 {
+		n = n.children[0]
+	}
+	tree.root = n
+	if n == nil {
+		tree.first, tree.last = nil, nil
+		return
+	}
+	n.parent = nil
+	tree.findEnds()
+}
+
+// Concat appends the items of other to the tree and leaves other empty.
+// The shorter tree is joined to the edge of the taller one at its own
+// height, so it runs in O(log n). Both trees must have the same options.
+func (tree *BxTree[T]) Concat(other *BxTree[T]) error {
+	if tree.sizes != other.sizes || tree.handles != other.handles || !slices.Equal(tree.measures, other.measures) {
+		return ErrIncompatibleTrees
+	}
+	if other.root == nil || tree == other {
+		return nil
+	}
+	defer func() {
+		other.root, other.first, other.last = nil, nil, nil
+	}()
+	if tree.root == nil {
+		tree.setRoot(other.root)
+		return nil
+	}
+
+	link(tree.last, other.first)
+	leftHeight, rightHeight := tree.root.height(), other.root.height()
+	if leftHeight >= rightHeight {
+		edge := tree.root
+		for range leftHeight - rightHeight {
+			edge = edge.children[len(edge.children)-1]
+		}
+		joined := tree.join(edge, other.root)
+		tree.addSiblings(joined[0], joined[1:])
+	} else {
+		edge := other.root
+		for range rightHeight - leftHeight - 1 {
+			edge = edge.children[0]
+		}
+		joined := tree.join(tree.root, edge.children[0])
+		edge.children[0] = joined[0]
+		joined[0].parent = edge
+		tree.root = other.root
+		tree.addSiblings(joined[0], joined[1:])
+	}
+	tree.setRoot(tree.root)
+	return nil
+}
+
+func (_node *node[T]) height() int {
+	height := 0
+	for n := _node; !n.isLeaf; n = n.children[0] {
+		height++
+	}
+	return height
+}
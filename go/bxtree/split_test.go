@@ -0,0 +1,71 @@
+package bxtree
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestSplitConcat(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	measure := NewMeasure(func(v int) int { return v % 3 })
+	newTree := func(n int) (*BxTree[int], []int) {
+		tree := NewMeasured(measure)
+		list := make([]int, n)
+		for i := range list {
+			list[i] = r.Int()
+		}
+		tree.InsertRange(0, list)
+		return tree, list
+	}
+
+	for i := 0; i < 300; i++ {
+		tree, list := newTree(r.Intn(60000))
+		index := r.Intn(len(list) + 1)
+		right, err := tree.Split(index)
+		if err != nil {
+			t.Fatalf("Split(%d) failed: %v", index, err)
+		}
+		checkTree(t, tree, list[:index])
+		checkTree(t, right, list[index:])
+
+		// Join back in the other order, with a tree of another height
+		other, otherList := newTree(r.Intn(20000))
+		if r.Intn(2) == 0 {
+			right.Concat(other)
+			checkTree(t, right, append(slices.Clone(list[index:]), otherList...))
+			right.Concat(tree)
+			checkTree(t, right, slices.Concat(list[index:], otherList, list[:index]))
+		} else {
+			other.Concat(right)
+			checkTree(t, other, append(slices.Clone(otherList), list[index:]...))
+			tree.Concat(other)
+			checkTree(t, tree, slices.Concat(list[:index], otherList, list[index:]))
+		}
+	}
+}
+
+func TestSplitEdges(t *testing.T) {
+	tree := New[int]()
+	for i := range 1000 {
+		tree.InsertAt(i, i)
+	}
+	right, _ := tree.Split(1000)
+	if right.Size() != 0 || tree.Size() != 1000 {
+		t.Errorf("Split at the end gave sizes %d and %d", tree.Size(), right.Size())
+	}
+	right, _ = tree.Split(0)
+	if right.Size() != 1000 || tree.Size() != 0 {
+		t.Errorf("Split at the start gave sizes %d and %d", tree.Size(), right.Size())
+	}
+	if _, err := tree.Split(1); err != ErrIndexOutOfBounds {
+		t.Errorf("Expected ErrIndexOutOfBounds, got %v", err)
+	}
+	tree.Concat(right)
+	if tree.Size() != 1000 || right.Size() != 0 {
+		t.Errorf("Concat to an empty tree gave sizes %d and %d", tree.Size(), right.Size())
+	}
+	if err := tree.Concat(NewMeasured(NewMeasure(func(v int) int { return v }))); err != ErrIncompatibleTrees {
+		t.Errorf("Expected ErrIncompatibleTrees, got %v", err)
+	}
+}
@@ -1,5 +1,6 @@
 package bxtree
 
+// Default node sizes, see WithLeafSize and WithInternalSize
 const (
 	INTERNAL_MIN_SIZE = 16
 	INTERNAL_MAX_SIZE = INTERNAL_MIN_SIZE * 2
@@ -11,13 +12,21 @@ type node[T any] struct {
 	isLeaf   bool
 	parent   *node[T]
 	size     int
-	items    []T        // only for leaf nodes
-	next     *node[T]   // only for leaf nodes
-	children []*node[T] // only for internal nodes
+	items    []T          // only for leaf nodes
+	next     *node[T]     // only for leaf nodes
+	prev     *node[T]     // only for leaf nodes
+	handles  []*Handle[T] // only for leaf nodes of trees with handles, one per item
+	children []*node[T]   // only for internal nodes
+	sums     []int        // sum of each measure over the subtree
 }
 
 type BxTree[T any] struct {
 	root  *node[T]
 	first *node[T]
 	last  *node[T]
+
+	sizes
+	handles  bool
+	measures []*Measure[T]
+	touched  []*node[T] // nodes whose sums are stale
 }
@@ -0,0 +1,118 @@
+package bxtree
+
+import "fmt"
+
+// Validate checks the structure of the tree: sizes and sums against the
+// content below each node, parent pointers, the leaf chain in both
+// directions, first and last, how full every node is, that all leaves are
+// at the same depth, and that handles point to their leaves. It returns an
+// ErrInvalidTree describing the first problem it finds.
+func (tree *BxTree[T]) Validate() error {
+	if tree.root == nil {
+		if tree.first != nil || tree.last != nil {
+			return fmt.Errorf("%w: empty tree has first or last leaf", ErrInvalidTree)
+		}
+		return nil
+	}
+	if tree.root.parent != nil {
+		return fmt.Errorf("%w: root has a parent", ErrInvalidTree)
+	}
+
+	var leaves []*node[T]
+	depth := -1
+	var check func(n *node[T], path string, level int) error
+	check = func(n *node[T], path string, level int) error {
+		if err := tree.checkNode(n); err != nil {
+			return fmt.Errorf("%w: node %s: %v", ErrInvalidTree, path, err)
+		}
+		if n.isLeaf {
+			if depth != -1 && level != depth {
+				return fmt.Errorf("%w: leaf %s at depth %d, expected %d", ErrInvalidTree, path, level, depth)
+			}
+			depth = level
+			leaves = append(leaves, n)
+			return nil
+		}
+		for i, child := range n.children {
+			if child.parent != n {
+				return fmt.Errorf("%w: child %s/%d does not point to its parent", ErrInvalidTree, path, i)
+			}
+			if err := check(child, fmt.Sprintf("%s/%d", path, i), level+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := check(tree.root, "root", 0); err != nil {
+		return err
+	}
+
+	if tree.first != leaves[0] || tree.last != leaves[len(leaves)-1] {
+		return fmt.Errorf("%w: first or last is not the leftmost or rightmost leaf", ErrInvalidTree)
+	}
+	for i, leaf := range leaves {
+		var prev, next *node[T]
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if i < len(leaves)-1 {
+			next = leaves[i+1]
+		}
+		if leaf.prev != prev || leaf.next != next {
+			return fmt.Errorf("%w: leaf chain broken at leaf %d of %d", ErrInvalidTree, i, len(leaves))
+		}
+	}
+	return nil
+}
+
+// checkNode checks the size, sums and occupancy of a single node.
+func (tree *BxTree[T]) checkNode(n *node[T]) error {
+	if n.isLeaf {
+		if n.size != len(n.items) {
+			return fmt.Errorf("size %d for %d items", n.size, len(n.items))
+		}
+		if len(n.items) > tree.leafMax() || (n != tree.root && len(n.items) < tree.leafMin) {
+			return fmt.Errorf("%d items, expected %d to %d", len(n.items), tree.leafMin, tree.leafMax())
+		}
+		if tree.handles && len(n.handles) != len(n.items) {
+			return fmt.Errorf("%d handles for %d items", len(n.handles), len(n.items))
+		}
+		for _, h := range n.handles {
+			if h != nil && h.leaf != n {
+				return fmt.Errorf("handle points to another leaf")
+			}
+		}
+	} else {
+		size := 0
+		for _, child := range n.children {
+			size += child.size
+		}
+		if n.size != size {
+			return fmt.Errorf("size %d, children sum to %d", n.size, size)
+		}
+		minChildren := tree.internalMin
+		if n == tree.root {
+			minChildren = 2
+		}
+		if len(n.children) > tree.internalMax() || len(n.children) < minChildren {
+			return fmt.Errorf("%d children, expected %d to %d", len(n.children), minChildren, tree.internalMax())
+		}
+	}
+
+	for m, measure := range tree.measures {
+		sum := 0
+		if n.isLeaf {
+			for _, item := range n.items {
+				sum += measure.of(item)
+			}
+		} else {
+			for _, child := range n.children {
+				sum += child.sums[m]
+			}
+		}
+		if n.sums[m] != sum {
+			return fmt.Errorf("sum %d of measure %d, expected %d", n.sums[m], m, sum)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package bxtree
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// TestValidateSingleOps validates after every InsertAt and DeleteAt, which
+// rebalance by borrowing from and merging with siblings.
+func TestValidateSingleOps(t *testing.T) {
+	for _, s := range [][2]int{{1, 2}, {3, 2}, {4, 3}, {LEAF_MIN_SIZE, INTERNAL_MIN_SIZE}} {
+		t.Run(fmt.Sprintf("leaf=%d/internal=%d", s[0], s[1]), func(t *testing.T) {
+			r := rand.New(rand.NewSource(7))
+			tree := New[int](WithLeafSize(s[0]), WithInternalSize(s[1]), WithMeasures(NewMeasure(func(v int) int { return v % 5 })))
+			list := []int{}
+			for i := 0; i < 8000; i++ {
+				// Grow and shrink in waves so that every level fills and empties
+				if len(list) == 0 || r.Float64() < 0.5+0.2*float64((i/1000)%2*2-1) {
+					index := r.Intn(len(list) + 1)
+					tree.InsertAt(index, i)
+					list = slices.Insert(list, index, i)
+				} else {
+					index := r.Intn(len(list))
+					tree.DeleteAt(index)
+					list = slices.Delete(list, index, index+1)
+				}
+				if err := tree.Validate(); err != nil {
+					t.Fatalf("Iteration %d: %v", i, err)
+				}
+			}
+			checkTree(t, tree, list)
+		})
+	}
+}
+
+func TestValidateFindsProblems(t *testing.T) {
+	newTree := func() *BxTree[int] {
+		tree, _ := FromSlice(make([]int, 1000), 1, WithLeafSize(4), WithInternalSize(2))
+		return tree
+	}
+	for name, corrupt := range map[string]func(tree *BxTree[int]){
+		"size":   func(tree *BxTree[int]) { tree.root.children[0].size++ },
+		"parent": func(tree *BxTree[int]) { tree.root.children[1].parent = tree.root.children[0] },
+		"chain":  func(tree *BxTree[int]) { tree.first.next = tree.last },
+		"last":   func(tree *BxTree[int]) { tree.last = tree.first },
+		"occupancy": func(tree *BxTree[int]) {
+			tree.first.items = tree.first.items[:1]
+			tree.refresh(tree.first)
+			for p := tree.first.parent; p != nil; p = p.parent {
+				tree.refresh(p)
+			}
+		},
+		"depth": func(tree *BxTree[int]) {
+			leaf := tree.root.children[0]
+			for !leaf.isLeaf {
+				leaf = leaf.children[0]
+			}
+			tree.root.children[0] = leaf
+			leaf.parent = tree.root
+		},
+	} {
+		tree := newTree()
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("Valid tree reported as invalid: %v", err)
+		}
+		corrupt(tree)
+		if err := tree.Validate(); !errors.Is(err, ErrInvalidTree) {
+			t.Errorf("Corrupted %s not found, got %v", name, err)
+		}
+	}
+}
+
+// FuzzTree runs the ops encoded in the input on a tree with small nodes,
+// three bytes per op, and validates after each one.
+func FuzzTree(f *testing.F) {
+	f.Add([]byte{0, 0, 5, 0, 0, 9, 1, 3, 4, 2, 1, 2, 3, 0, 2})
+	f.Add([]byte("insert and delete some ranges in a small tree"))
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		tree := New[int](WithLeafSize(2), WithInternalSize(2))
+		list := []int{}
+		for i := 0; i+2 < len(ops); i += 3 {
+			index := int(ops[i+1]) % (len(list) + 1)
+			n := int(ops[i+2])
+			switch ops[i] % 4 {
+			case 0:
+				tree.InsertAt(index, i)
+				list = slices.Insert(list, index, i)
+			case 1:
+				if index < len(list) {
+					tree.DeleteAt(index)
+					list = slices.Delete(list, index, index+1)
+				}
+			case 2:
+				items := make([]int, n)
+				for j := range items {
+					items[j] = i + j
+				}
+				tree.InsertRange(index, items)
+				list = slices.Insert(list, index, items...)
+			default:
+				n = min(n, len(list)-index)
+				tree.DeleteRange(index, n)
+				list = slices.Delete(list, index, index+n)
+			}
+			if err := tree.Validate(); err != nil {
+				t.Fatalf("Op %d: %v", i/3, err)
+			}
+		}
+		checkTree(t, tree, list)
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"maps"
+	"sync"
+)
+
+// ==========================================
+// Concurrency-safe Document
+// ==========================================
+
+// ConcurrentDocument wraps a CRDTDocument with a read/write lock so that it
+// can be shared between goroutines. Reads (GetString, View) take the read
+// lock and always observe a snapshot consistent with the oplog.
+type ConcurrentDocument struct {
+	mu  sync.RWMutex
+	doc *CRDTDocument
+}
+
+func NewConcurrentDocument(agent int) *ConcurrentDocument {
+	return &ConcurrentDocument{
+		doc: NewCRDTDocument(agent),
+	}
+}
+
+func (doc *ConcurrentDocument) Ins(pos int, text string) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	doc.doc.Ins(pos, text)
+}
+
+func (doc *ConcurrentDocument) Del(pos int, delLen int) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	doc.doc.Del(pos, delLen)
+}
+
+func (doc *ConcurrentDocument) GetString() string {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+	return doc.doc.GetString()
+}
+
+// MergeFrom pulls the ops of other that doc lacks. Only those ops are
+// copied under other's read lock, and doc's lock is not held at the same
+// time, so two documents merging from each other cannot deadlock.
+func (doc *ConcurrentDocument) MergeFrom(other *ConcurrentDocument) {
+	if doc == other {
+		return
+	}
+	doc.mu.RLock()
+	version := maps.Clone(doc.doc.OpLog.Version)
+	doc.mu.RUnlock()
+
+	other.mu.RLock()
+	ops := OpsSince(other.doc.OpLog, version)
+	other.mu.RUnlock()
+	if len(ops) == 0 {
+		return
+	}
+
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	// The ops come from a valid document, in causal order
+	if err := doc.doc.applyWireOps(ops); err != nil {
+		panic(err)
+	}
+}
+
+// View runs f with the read lock held. f must not keep a reference to the
+// document or modify it.
+func (doc *ConcurrentDocument) View(f func(doc *CRDTDocument)) {
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+	f(doc.doc)
+}
+
+// Update runs f with the write lock held, for edits that must happen
+// atomically (e.g. reading a position and inserting at it).
+func (doc *ConcurrentDocument) Update(f func(doc *CRDTDocument)) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	f(doc.doc)
+}
+
+// OnLocalOps, OnRemoteOps and OnPatch subscribe to the wrapped document.
+// Callbacks run with the write lock held and must not call back into doc.
+func (doc *ConcurrentDocument) OnLocalOps(fn func(ops []Op[rune])) *Subscription {
+	return doc.doc.OnLocalOps(fn)
+}
+
+func (doc *ConcurrentDocument) OnRemoteOps(fn func(ops []Op[rune])) *Subscription {
+	return doc.doc.OnRemoteOps(fn)
+}
+
+func (doc *ConcurrentDocument) OnPatch(fn func(patch TextPatch)) *Subscription {
+	return doc.doc.OnPatch(fn)
+}
+
+func (doc *ConcurrentDocument) Reset() {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	doc.doc.Reset()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentSharedDocument(t *testing.T) {
+	doc := NewConcurrentDocument(0)
+	workers := 8
+	perWorker := 200
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for range perWorker {
+				doc.Update(func(d *CRDTDocument) {
+					d.Ins(r.Intn(len(d.Branch.Snapshot)+1), "x")
+				})
+				_ = doc.GetString()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len([]rune(doc.GetString())); got != workers*perWorker {
+		t.Fatalf("Expected %d characters, got %d", workers*perWorker, got)
+	}
+}
+
+func TestConcurrentInsDelMerge(t *testing.T) {
+	docs := []*ConcurrentDocument{
+		NewConcurrentDocument(0),
+		NewConcurrentDocument(1),
+		NewConcurrentDocument(2),
+		NewConcurrentDocument(3),
+	}
+
+	var wg sync.WaitGroup
+	for i, doc := range docs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(i)))
+			alphabet := []rune("abcdefghijklmnopqrstuvwxyz")
+			for range 300 {
+				switch r.Intn(3) {
+				case 0:
+					doc.MergeFrom(docs[r.Intn(len(docs))])
+				default:
+					doc.Update(func(d *CRDTDocument) {
+						length := len(d.Branch.Snapshot)
+						if length == 0 || r.Float64() < 0.65 {
+							d.Ins(r.Intn(length+1), string(alphabet[r.Intn(len(alphabet))]))
+						} else {
+							pos := r.Intn(length)
+							d.Del(pos, r.Intn(min(length-pos, 3))+1)
+						}
+					})
+				}
+			}
+		}()
+	}
+
+	// Readers running alongside the writers
+	for _, doc := range docs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				doc.View(func(d *CRDTDocument) {
+					if len([]rune(d.GetString())) != len(d.Branch.Snapshot) {
+						t.Errorf("Inconsistent snapshot read")
+					}
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Merge everything in parallel in both directions. Edits are done, so
+	// each document ends up with every op.
+	for _, a := range docs {
+		for _, b := range docs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				a.MergeFrom(b)
+			}()
+		}
+	}
+	wg.Wait()
+
+	expected := docs[0].GetString()
+	for i, doc := range docs[1:] {
+		if got := doc.GetString(); got != expected {
+			t.Fatalf("Document %d diverged: %q vs %q", i+1, got, expected)
+		}
+	}
+}
+
+func TestConcurrentMergeFromOnlyNewOps(t *testing.T) {
+	a := NewConcurrentDocument(1)
+	b := NewConcurrentDocument(2)
+	a.Ins(0, "hello")
+	b.MergeFrom(a)
+	a.Update(func(d *CRDTDocument) {
+		d.Ins(5, " world")
+		d.Set("title", "greeting")
+	})
+
+	var remote [][]Op[rune]
+	b.OnRemoteOps(func(ops []Op[rune]) { remote = append(remote, ops) })
+	b.MergeFrom(a)
+	b.MergeFrom(a) // Nothing new
+	if len(remote) != 1 || len(remote[0]) != 7 {
+		t.Fatalf("Expected one batch of the 7 new ops, got %v", remote)
+	}
+	b.View(func(d *CRDTDocument) {
+		if v, _ := d.Get("title"); d.GetString() != "hello world" || v != "greeting" {
+			t.Fatalf("Unexpected merge %q, %v", d.GetString(), v)
+		}
+	})
+}
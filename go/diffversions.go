@@ -0,0 +1,100 @@
+package main
+
+// ==========================================
+// Version Diffs
+// ==========================================
+
+// historyOf returns which ops are in the history of frontier, frontier
+// included.
+func historyOf[T any](log *OpLog[T], frontier []LV) []bool {
+	seen := make([]bool, len(log.Ops))
+	stack := append([]LV{}, frontier...)
+	for len(stack) > 0 {
+		lv := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[lv] {
+			continue
+		}
+		seen[lv] = true
+		stack = append(stack, log.Ops[lv].Parents...)
+	}
+	return seen
+}
+
+// visibleAt moves doc to version and returns which of its items are visible
+// there.
+func visibleAt[T any](doc *CRDTDoc, log *OpLog[T], version []LV) map[*CRDTItem]bool {
+	diffRes := Diff(log, doc.CurrentVersion, version)
+	for _, lv := range diffRes.AOnly {
+		Retreat(doc, log, lv)
+	}
+	for _, lv := range diffRes.BOnly {
+		Advance(doc, log, lv)
+	}
+	doc.CurrentVersion = version
+
+	visible := map[*CRDTItem]bool{}
+	for _, item := range doc.Items {
+		if IsVisible(item) {
+			visible[item] = true
+		}
+	}
+	return visible
+}
+
+// DiffVersions returns the patches that turn the content at version a into
+// the content at version b, in order. The ops of both versions are replayed
+// together, so the patches follow the edits that were made (an insert next
+// to an equal item is never mistaken for the old one) rather than a minimal
+// textual diff.
+func DiffVersions[T any](log *OpLog[T], a []LV, b []LV) []Patch[T] {
+	doc := &CRDTDoc{
+		Items:          []*CRDTItem{},
+		CurrentVersion: []LV{},
+		DelTargets:     make(map[LV]LV),
+		ItemsByLV:      make(map[LV]*CRDTItem),
+	}
+	inA, inB := historyOf(log, a), historyOf(log, b)
+	for lv := range len(log.Ops) {
+		if (inA[lv] || inB[lv]) && log.Ops[lv].Obj() == RootObj {
+			Do1Operation(doc, log, LV(lv), nil)
+		}
+	}
+
+	visibleA := visibleAt(doc, log, a)
+	visibleB := visibleAt(doc, log, b)
+
+	var patches []Patch[T]
+	pos := 0
+	for _, item := range doc.Items {
+		switch {
+		case visibleA[item] && visibleB[item]:
+			pos++
+		case visibleA[item]:
+			patches = appendPatch(patches, Patch[T]{Pos: pos, DelLen: 1})
+		case visibleB[item]:
+			content := item
+			if item.Elem != nil {
+				content = item.Elem.Orig
+			}
+			patches = appendPatch(patches, Patch[T]{Pos: pos, Content: []T{log.Ops[content.LV].Content}})
+			pos++
+		}
+	}
+	return patches
+}
+
+// Changes returns the patches from the content at version since to the
+// current content, e.g. for a "changed since yesterday" view.
+func (doc *Document[T]) Changes(since []LV) []Patch[T] {
+	return DiffVersions(doc.OpLog, since, doc.OpLog.Frontier)
+}
+
+// DiffText is DiffVersions for the text of the document.
+func (doc *CRDTDocument) DiffText(a []LV, b []LV) []TextPatch {
+	patches := []TextPatch{}
+	for _, p := range DiffVersions(doc.OpLog, a, b) {
+		patches = append(patches, TextPatch{Pos: p.Pos, DelLen: p.DelLen, Text: string(p.Content)})
+	}
+	return patches
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func contentAt[T any](log *OpLog[T], version []LV) []T {
+	branch := NewBranch[T]()
+	CheckoutFancy(log, branch, version)
+	return branch.Snapshot
+}
+
+func TestDiffText(t *testing.T) {
+	doc := NewCRDTDocument(1)
+	doc.Ins(0, "the cat sat")
+	yesterday := slices.Clone(doc.OpLog.Frontier)
+	doc.Del(4, 3)
+	doc.Ins(4, "dog")
+	doc.Ins(11, " down")
+
+	expected := []TextPatch{{Pos: 4, DelLen: 3, Text: "dog"}, {Pos: 11, Text: " down"}}
+	if got := doc.DiffText(yesterday, doc.OpLog.Frontier); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	expected = []TextPatch{{Pos: 4, DelLen: 3, Text: "cat"}, {Pos: 11, DelLen: 5}}
+	if got := doc.DiffText(doc.OpLog.Frontier, yesterday); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestDiffVersionsRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	docs := []*Document[int]{NewDocument[int](0), NewDocument[int](1), NewDocument[int](2)}
+	var versions [][]LV
+
+	next := 0
+	for range 150 {
+		doc := docs[r.Intn(len(docs))]
+		switch {
+		case doc.Len() == 0 || r.Float64() < 0.5:
+			doc.Ins(r.Intn(doc.Len()+1), []int{next, next + 1})
+			next += 2
+		case r.Float64() < 0.7:
+			doc.Del(r.Intn(doc.Len()), 1)
+		default:
+			doc.Move(r.Intn(doc.Len()), r.Intn(doc.Len()))
+		}
+		docs[0].MergeFrom(docs[r.Intn(len(docs))])
+		// Versions of the merged log, including concurrent ones
+		versions = append(versions, slices.Clone(docs[0].OpLog.Frontier))
+	}
+
+	log := docs[0].OpLog
+	for range 200 {
+		a, b := versions[r.Intn(len(versions))], versions[r.Intn(len(versions))]
+		content := contentAt(log, a)
+		for _, p := range DiffVersions(log, a, b) {
+			content = slices.Insert(slices.Delete(content, p.Pos, p.Pos+p.DelLen), p.Pos, p.Content...)
+		}
+		if expected := contentAt(log, b); !slices.Equal(content, expected) {
+			t.Fatalf("Diff from %v to %v gives %v, expected %v", a, b, content, expected)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type todo struct {
+	Title string
+	Done  bool
+}
+
+func TestDocumentGenericMerge(t *testing.T) {
+	a := NewDocument[todo](1)
+	b := NewDocument[todo](2)
+
+	a.Ins(0, []todo{{Title: "write tests"}, {Title: "ship"}})
+	b.MergeFrom(a)
+	b.Ins(1, []todo{{Title: "review", Done: true}})
+	a.Del(1, 1)
+
+	a.MergeFrom(b)
+	b.MergeFrom(a)
+
+	expected := []todo{{Title: "write tests"}, {Title: "review", Done: true}}
+	if !reflect.DeepEqual(a.Items(), expected) || !reflect.DeepEqual(b.Items(), expected) {
+		t.Fatalf("Unexpected items %v / %v", a.Items(), b.Items())
+	}
+	if a.Len() != 2 {
+		t.Fatalf("Expected length 2, got %d", a.Len())
+	}
+}
+
+func TestDocumentOfLines(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	docs := []*Document[string]{NewDocument[string](0), NewDocument[string](1), NewDocument[string](2)}
+
+	var patched [3][]string
+	for i, doc := range docs {
+		doc.OnPatch(func(p Patch[string]) {
+			lines := append([]string{}, patched[i][:p.Pos]...)
+			lines = append(lines, p.Content...)
+			patched[i] = append(lines, patched[i][p.Pos+p.DelLen:]...)
+		})
+	}
+
+	for i := range 200 {
+		doc := docs[r.Intn(len(docs))]
+		if doc.Len() == 0 || r.Float64() < 0.6 {
+			line := strings.Repeat("x", r.Intn(10))
+			doc.Ins(r.Intn(doc.Len()+1), []string{line})
+		} else {
+			doc.Del(r.Intn(doc.Len()), 1)
+		}
+
+		a, b := docs[r.Intn(len(docs))], docs[r.Intn(len(docs))]
+		if a != b {
+			a.MergeFrom(b)
+			b.MergeFrom(a)
+			if !reflect.DeepEqual(a.Items(), b.Items()) {
+				t.Fatalf("Iteration %d: documents diverged", i)
+			}
+		}
+		for j, doc := range docs {
+			if !reflect.DeepEqual(patched[j], doc.Items()) && !(len(patched[j]) == 0 && doc.Len() == 0) {
+				t.Fatalf("Iteration %d: patches do not match document %d", i, j)
+			}
+		}
+	}
+}
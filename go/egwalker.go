@@ -2,7 +2,10 @@ package main
 
 import (
 	"container/heap"
+	"egwalker/bxtree"
 	"fmt"
+	"maps"
+	"slices"
 	"sort"
 	"strings"
 )
@@ -21,18 +24,76 @@ type Id struct {
 type OpType string
 
 const (
-	OpTypeIns OpType = "ins"
-	OpTypeDel OpType = "del"
+	OpTypeIns  OpType = "ins"
+	OpTypeDel  OpType = "del"
+	OpTypeMove OpType = "move"
+	OpTypeMark OpType = "mark"
+	OpTypeSet  OpType = "set"
 )
 
 type Op[T any] struct {
 	Type    OpType
 	Content T
-	Pos     int // Original position for local ops
+	Pos     int    // Original position for local ops
+	Ext     *OpExt // Payload of the other op types, nil for plain inserts and deletes
 	Id      Id
 	Parents []LV
 }
 
+// OpExt holds what only some ops need, so that the inserts and deletes that
+// make up most of a text stay small. Read it through the accessors of Op,
+// which treat a nil Ext as all zero.
+type OpExt struct {
+	To    int     // Destination of a move, as a position once the item is removed
+	Mark  *Mark   // Formatting set by a mark op
+	Key   string  // Key written by a set op
+	Value any     // Value written by a set op, nil deletes the key
+	Obj   ObjId   // Container of the op in a JSON document, RootObj otherwise
+	Make  ObjKind // Kind of container created by the op, if any
+}
+
+func (op Op[T]) To() int {
+	if op.Ext == nil {
+		return 0
+	}
+	return op.Ext.To
+}
+
+func (op Op[T]) Mark() *Mark {
+	if op.Ext == nil {
+		return nil
+	}
+	return op.Ext.Mark
+}
+
+func (op Op[T]) Key() string {
+	if op.Ext == nil {
+		return ""
+	}
+	return op.Ext.Key
+}
+
+func (op Op[T]) Value() any {
+	if op.Ext == nil {
+		return nil
+	}
+	return op.Ext.Value
+}
+
+func (op Op[T]) Obj() ObjId {
+	if op.Ext == nil {
+		return RootObj
+	}
+	return op.Ext.Obj
+}
+
+func (op Op[T]) Make() ObjKind {
+	if op.Ext == nil {
+		return ""
+	}
+	return op.Ext.Make
+}
+
 type RemoteVersion map[int]int
 
 type OpLog[T any] struct {
@@ -73,6 +134,25 @@ func (log *OpLog[T]) PushLocalOp(agent int, op Op[T]) {
 	log.Version[agent] = seq
 }
 
+// PushLocalOpAt is PushLocalOp for an op made on top of version rather than
+// the frontier, e.g. an edit to a branch that has not seen every op.
+func (log *OpLog[T]) PushLocalOpAt(agent int, op Op[T], version []LV) LV {
+	lastSeq, ok := log.Version[agent]
+	if !ok {
+		lastSeq = -1
+	}
+	seq := lastSeq + 1
+
+	lv := LV(len(log.Ops))
+	op.Id = Id{Agent: agent, Seq: seq}
+	op.Parents = SortLVs(slices.Clone(version))
+
+	log.Ops = append(log.Ops, op)
+	log.Frontier = AdvanceFrontier(log.Frontier, lv, op.Parents)
+	log.Version[agent] = seq
+	return lv
+}
+
 func LocalInsert[T any](log *OpLog[T], agent int, pos int, content []T) {
 	currentPos := pos
 	for _, c := range content {
@@ -99,10 +179,27 @@ func LocalDelete[T any](log *OpLog[T], agent int, pos int, delLen int) {
 	}
 }
 
+func LocalMove[T any](log *OpLog[T], agent int, from int, to int) {
+	log.PushLocalOp(agent, Op[T]{
+		Type: OpTypeMove,
+		Pos:  from,
+		Ext:  &OpExt{To: to},
+	})
+}
+
 func IdEq(a, b Id) bool {
 	return a.Agent == b.Agent && a.Seq == b.Seq
 }
 
+// IdLess orders ids by agent, then by seq. Ops of one agent can be
+// concurrent, e.g. on two branches, so the agent alone is not a total order.
+func IdLess(a, b Id) bool {
+	if a.Agent != b.Agent {
+		return a.Agent < b.Agent
+	}
+	return a.Seq < b.Seq
+}
+
 func IdToLV[T any](log *OpLog[T], id Id) LV {
 	for i, op := range log.Ops {
 		if IdEq(op.Id, id) {
@@ -167,6 +264,36 @@ func PushRemoteOp[T any](log *OpLog[T], op Op[T], parentIds []Id) {
 	log.Version[agent] = seq
 }
 
+// Clone returns a copy of the oplog that can be read while the original keeps
+// growing. Ops are never mutated once pushed, so their Parents are shared.
+func (log *OpLog[T]) Clone() *OpLog[T] {
+	return &OpLog[T]{
+		Ops:      slices.Clone(log.Ops),
+		Frontier: slices.Clone(log.Frontier),
+		Version:  maps.Clone(log.Version),
+	}
+}
+
+// truncate drops the ops from n on, e.g. ops from a peer that turned out
+// not to fit the document.
+func (log *OpLog[T]) truncate(n int) {
+	log.Ops = slices.Delete(log.Ops, n, len(log.Ops))
+	log.Version = make(RemoteVersion)
+	isParent := make([]bool, n)
+	for _, op := range log.Ops {
+		log.Version[op.Id.Agent] = max(log.Version[op.Id.Agent], op.Id.Seq)
+		for _, p := range op.Parents {
+			isParent[p] = true
+		}
+	}
+	log.Frontier = []LV{}
+	for lv := range n {
+		if !isParent[lv] {
+			log.Frontier = append(log.Frontier, LV(lv))
+		}
+	}
+}
+
 func MergeInto[T any](dest *OpLog[T], src *OpLog[T]) {
 	for _, op := range src.Ops {
 		parentIds := make([]Id, len(op.Parents))
@@ -175,6 +302,16 @@ func MergeInto[T any](dest *OpLog[T], src *OpLog[T]) {
 		}
 		// Create a copy of op to avoid mutating source if we were to modify it (we don't, but safe practice)
 		newOp := op
+		if op.Mark() != nil || op.Obj() != RootObj {
+			ext := *op.Ext
+			if ext.Mark != nil {
+				ext.Mark = ext.Mark.remap(func(lv LV) LV { return IdToLV(dest, src.Ops[lv].Id) })
+			}
+			if ext.Obj != RootObj {
+				ext.Obj = ObjId(IdToLV(dest, src.Ops[ext.Obj-1].Id) + 1)
+			}
+			newOp.Ext = &ext
+		}
 		PushRemoteOp(dest, newOp, parentIds)
 	}
 }
@@ -283,6 +420,73 @@ type CRDTItem struct {
 	OriginRight LV // -1 if none
 	Deleted     bool
 	CurState    int
+	Elem        *CRDTElement // nil unless the item has been moved
+
+	handle *bxtree.Handle[*CRDTItem] // position of the item in the doc's order tree
+}
+
+// CRDTElement tracks an item that has been moved. Each move inserts a new
+// placement item; the original insert keeps the element's delete state in
+// its CurState. Of the placements present at a version, the one whose op
+// has the highest (lamport, agent) is where the element is: causally later
+// moves always win, concurrent ones are ordered deterministically. Deleted
+// and Shown are the same rules applied to every op merged so far, i.e. what
+// the snapshot contains.
+type CRDTElement struct {
+	Orig       *CRDTItem
+	Placements []Placement
+	Deleted    bool
+	Shown      *CRDTItem // placement currently in the snapshot, nil if deleted
+}
+
+type Placement struct {
+	Item    *CRDTItem
+	Lamport int
+	Agent   int
+}
+
+func (a Placement) Before(b Placement) bool {
+	if a.Lamport != b.Lamport {
+		return a.Lamport < b.Lamport
+	}
+	return a.Agent < b.Agent
+}
+
+// Current returns the placement of the element at the current version, or nil
+// if it is not part of the document.
+func (elem *CRDTElement) Current() *CRDTItem {
+	if elem.Orig.CurState != StateInserted {
+		return nil
+	}
+	var best *Placement
+	for i := range elem.Placements {
+		p := &elem.Placements[i]
+		if p.Item.CurState == StateNotYetInserted {
+			continue
+		}
+		if best == nil || best.Before(*p) {
+			best = p
+		}
+	}
+	return best.Item
+}
+
+func (elem *CRDTElement) placement(item *CRDTItem) Placement {
+	for _, p := range elem.Placements {
+		if p.Item == item {
+			return p
+		}
+	}
+	panic("Item is not a placement of the element")
+}
+
+// IsVisible reports whether the item is part of the document at the current
+// version.
+func IsVisible(item *CRDTItem) bool {
+	if item.Elem == nil {
+		return item.CurState == StateInserted
+	}
+	return item.Elem.Current() == item
 }
 
 type CRDTDoc struct {
@@ -290,12 +494,48 @@ type CRDTDoc struct {
 	CurrentVersion []LV
 	DelTargets     map[LV]LV        // Map opLV (delete op) -> targetLV
 	ItemsByLV      map[LV]*CRDTItem // Map LV -> CRDTItem
+	Obj            ObjId            // Only ops of this container are replayed
+
+	lamports []int                     // Lamport timestamps of the ops, computed lazily
+	order    *bxtree.BxTree[*CRDTItem] // Items again, to find the index of an item by its handle
+}
+
+// Lamport returns the Lamport timestamp of an op: one more than the highest
+// of its parents. Placeholder items come before every op.
+func Lamport[T any](doc *CRDTDoc, log *OpLog[T], lv LV) int {
+	return lamport(&doc.lamports, log, lv)
+}
+
+// lamport computes Lamport timestamps up to lv into cache.
+func lamport[T any](cache *[]int, log *OpLog[T], lv LV) int {
+	if int(lv) >= len(log.Ops) {
+		return -1
+	}
+	for i := len(*cache); i <= int(lv); i++ {
+		l := 0
+		for _, p := range log.Ops[i].Parents {
+			l = max(l, (*cache)[p]+1)
+		}
+		*cache = append(*cache, l)
+	}
+	return (*cache)[lv]
+}
+
+func newPlacement[T any](doc *CRDTDoc, log *OpLog[T], item *CRDTItem) Placement {
+	agent := -1
+	if int(item.LV) < len(log.Ops) {
+		agent = log.Ops[item.LV].Id.Agent
+	}
+	return Placement{Item: item, Lamport: Lamport(doc, log, item.LV), Agent: agent}
 }
 
 func Retreat[T any](doc *CRDTDoc, log *OpLog[T], opLv LV) {
 	op := log.Ops[opLv]
+	if op.Type == OpTypeMark || op.Type == OpTypeSet || op.Obj() != doc.Obj {
+		return // Not a sequence op of this container
+	}
 	var targetLV LV
-	if op.Type == OpTypeIns {
+	if op.Type == OpTypeIns || op.Type == OpTypeMove {
 		targetLV = opLv
 	} else {
 		targetLV = doc.DelTargets[opLv]
@@ -307,8 +547,11 @@ func Retreat[T any](doc *CRDTDoc, log *OpLog[T], opLv LV) {
 
 func Advance[T any](doc *CRDTDoc, log *OpLog[T], opLv LV) {
 	op := log.Ops[opLv]
+	if op.Type == OpTypeMark || op.Type == OpTypeSet || op.Obj() != doc.Obj {
+		return // Not a sequence op of this container
+	}
 	var targetLV LV
-	if op.Type == OpTypeIns {
+	if op.Type == OpTypeIns || op.Type == OpTypeMove {
 		targetLV = opLv
 	} else {
 		targetLV = doc.DelTargets[opLv]
@@ -327,7 +570,44 @@ func FindItemIdxAtLV(items []*CRDTItem, lv LV) int {
 	panic("Could not find item")
 }
 
-func Integrate[T any](doc *CRDTDoc, log *OpLog[T], newItem *CRDTItem, idx int, endPos int, snapshot *[]T) {
+// itemOrder returns the order tree of the doc, building it from Items the
+// first time.
+func itemOrder(doc *CRDTDoc) *bxtree.BxTree[*CRDTItem] {
+	if doc.order == nil {
+		doc.order, _ = bxtree.FromSlice(doc.Items, 0.75, bxtree.WithHandles())
+		for i, item := range doc.Items {
+			item.handle, _ = doc.order.Handle(i)
+		}
+	}
+	return doc.order
+}
+
+// itemIdx returns the index in doc.Items of the item with the given LV. It
+// is the O(log n) version of FindItemIdxAtLV.
+func itemIdx(doc *CRDTDoc, lv LV) int {
+	item, ok := doc.ItemsByLV[lv]
+	if !ok {
+		panic("Could not find item")
+	}
+	idx, err := itemOrder(doc).IndexOf(item.handle)
+	if err != nil {
+		panic(err)
+	}
+	return idx
+}
+
+// insertItem inserts item into doc.Items at idx, keeping the order tree in
+// step.
+func insertItem(doc *CRDTDoc, idx int, item *CRDTItem) {
+	order := itemOrder(doc)
+	doc.Items = slices.Insert(doc.Items, idx, item)
+	order.InsertAt(idx, item)
+	item.handle, _ = order.Handle(idx)
+}
+
+// Integrate places newItem in the document and returns its snapshot position.
+// The content of inserts is spliced into the snapshot there.
+func Integrate[T any](doc *CRDTDoc, log *OpLog[T], newItem *CRDTItem, idx int, endPos int, snapshot *[]T) int {
 	//func Integrate[T any](doc *CRDTDoc, log *OpLog[T], newItem *CRDTItem, idx int, endPos int, snapshot *bxtree.BxTree[T]) {
 	scanIdx := idx
 	scanEndPos := endPos
@@ -335,7 +615,7 @@ func Integrate[T any](doc *CRDTDoc, log *OpLog[T], newItem *CRDTItem, idx int, e
 	left := scanIdx - 1
 	right := len(doc.Items)
 	if newItem.OriginRight != -1 {
-		right = FindItemIdxAtLV(doc.Items, newItem.OriginRight)
+		right = itemIdx(doc, newItem.OriginRight)
 	}
 
 	scanning := false
@@ -349,19 +629,16 @@ func Integrate[T any](doc *CRDTDoc, log *OpLog[T], newItem *CRDTItem, idx int, e
 
 		oleft := -1
 		if other.OriginLeft != -1 {
-			oleft = FindItemIdxAtLV(doc.Items, other.OriginLeft)
+			oleft = itemIdx(doc, other.OriginLeft)
 		}
 
 		oright := len(doc.Items)
 		if other.OriginRight != -1 {
-			oright = FindItemIdxAtLV(doc.Items, other.OriginRight)
+			oright = itemIdx(doc, other.OriginRight)
 		}
 
-		newItemAgent := log.Ops[newItem.LV].Id.Agent
-		otherAgent := log.Ops[other.LV].Id.Agent
-
 		// Concurrent insert ordering logic
-		if oleft < left || (oleft == left && oright == right && newItemAgent < otherAgent) {
+		if oleft < left || (oleft == left && oright == right && IdLess(log.Ops[newItem.LV].Id, log.Ops[other.LV].Id)) {
 			break
 		}
 
@@ -381,15 +658,14 @@ func Integrate[T any](doc *CRDTDoc, log *OpLog[T], newItem *CRDTItem, idx int, e
 	}
 
 	// Insert into document list
-	// Go slice insertion: append(items[:idx], append([]*Item{newItem}, items[idx:]...)...)
-	doc.Items = append(doc.Items[:idx], append([]*CRDTItem{newItem}, doc.Items[idx:]...)...)
+	insertItem(doc, idx, newItem)
 
 	op := log.Ops[newItem.LV]
-	if op.Type != OpTypeIns {
+	if op.Type == OpTypeDel {
 		panic("Cannot insert a delete")
 	}
 
-	if snapshot != nil {
+	if snapshot != nil && op.Type == OpTypeIns {
 		// snapshot splice
 		*snapshot = append((*snapshot)[:endPos], append([]T{op.Content}, (*snapshot)[endPos:]...)...)
 		//err := snapshot.InsertAt(endPos, op.Content)
@@ -397,6 +673,7 @@ func Integrate[T any](doc *CRDTDoc, log *OpLog[T], newItem *CRDTItem, idx int, e
 		//	panic("Snapshot insert failed")
 		//}
 	}
+	return endPos
 }
 
 func FindByCurrentPos(items []*CRDTItem, targetPos int) (int, int) {
@@ -409,7 +686,7 @@ func FindByCurrentPos(items []*CRDTItem, targetPos int) (int, int) {
 			panic("Past end of items list")
 		}
 		item := items[idx]
-		if item.CurState == StateInserted {
+		if IsVisible(item) {
 			curPos++
 		}
 		if !item.Deleted {
@@ -419,16 +696,23 @@ func FindByCurrentPos(items []*CRDTItem, targetPos int) (int, int) {
 	return idx, endPos
 }
 
-func Apply[T any](doc *CRDTDoc, log *OpLog[T], snapshot *[]T, opLv LV) {
+// Apply runs the op at opLv against the document and returns the patches it
+// made to the snapshot. When snapshot is nil the patches of moves have no
+// content.
+func Apply[T any](doc *CRDTDoc, log *OpLog[T], snapshot *[]T, opLv LV) []Patch[T] {
 	//func Apply[T any](doc *CRDTDoc, log *OpLog[T], snapshot *bxtree.BxTree[T], opLv LV) {
 	op := log.Ops[opLv]
+	if op.Obj() != doc.Obj {
+		return nil
+	}
 
-	if op.Type == OpTypeDel {
+	switch op.Type {
+	case OpTypeDel:
 		// Delete
 		idx, endPos := FindByCurrentPos(doc.Items, op.Pos)
 
 		// Scan forward to find actual item
-		for doc.Items[idx].CurState != StateInserted {
+		for !IsVisible(doc.Items[idx]) {
 			if !doc.Items[idx].Deleted {
 				endPos++
 			}
@@ -436,9 +720,14 @@ func Apply[T any](doc *CRDTDoc, log *OpLog[T], snapshot *[]T, opLv LV) {
 		}
 
 		item := doc.Items[idx]
+		if item.Elem != nil {
+			return deleteElement(doc, item.Elem, snapshot, opLv)
+		}
 
+		var patches []Patch[T]
 		if !item.Deleted {
 			item.Deleted = true
+			patches = []Patch[T]{{Pos: endPos, DelLen: 1}}
 			if snapshot != nil {
 				// snapshot splice remove 1
 				*snapshot = append((*snapshot)[:endPos], (*snapshot)[endPos+1:]...)
@@ -451,42 +740,152 @@ func Apply[T any](doc *CRDTDoc, log *OpLog[T], snapshot *[]T, opLv LV) {
 
 		item.CurState = 1 // Deleted(1)
 		doc.DelTargets[opLv] = item.LV
+		return patches
 
-	} else {
-		// Insert
-		idx, endPos := FindByCurrentPos(doc.Items, op.Pos)
+	case OpTypeMove:
+		return applyMove(doc, log, snapshot, opLv)
 
-		if idx >= 1 && doc.Items[idx-1].CurState != StateInserted {
-			panic("Item to the left is not inserted!")
+	case OpTypeMark, OpTypeSet:
+		return nil
+
+	case OpTypeIns:
+		idx, endPos := FindByCurrentPos(doc.Items, op.Pos)
+		item := &CRDTItem{
+			LV:       opLv,
+			Deleted:  false,
+			CurState: StateInserted,
 		}
+		setOrigins(doc, item, idx)
+		doc.ItemsByLV[opLv] = item
+
+		pos := Integrate(doc, log, item, idx, endPos, snapshot)
+		return []Patch[T]{{Pos: pos, Content: []T{op.Content}}}
+
+	default:
+		panic("Unknown op type " + string(op.Type))
+	}
+}
+
+// setOrigins sets the origins of an item about to be inserted at idx.
+func setOrigins(doc *CRDTDoc, item *CRDTItem, idx int) {
+	if idx >= 1 && !IsVisible(doc.Items[idx-1]) {
+		panic("Item to the left is not inserted!")
+	}
+
+	item.OriginLeft = LV(-1)
+	if idx > 0 {
+		item.OriginLeft = doc.Items[idx-1].LV
+	}
 
-		originLeft := LV(-1)
-		if idx > 0 {
-			originLeft = doc.Items[idx-1].LV
+	item.OriginRight = LV(-1)
+	for i := idx; i < len(doc.Items); i++ {
+		item2 := doc.Items[i]
+		if item2.CurState != StateNotYetInserted {
+			item.OriginRight = item2.LV
+			break
 		}
+	}
+}
 
-		originRight := LV(-1)
-		for i := idx; i < len(doc.Items); i++ {
-			item2 := doc.Items[i]
-			if item2.CurState != StateNotYetInserted {
-				originRight = item2.LV
-				break
-			}
+// SnapshotPos returns the position of item in the snapshot, counting the
+// items before it that are not deleted.
+func SnapshotPos(doc *CRDTDoc, item *CRDTItem) int {
+	pos := 0
+	for _, other := range doc.Items {
+		if other == item {
+			return pos
+		}
+		if !other.Deleted {
+			pos++
 		}
+	}
+	panic("Could not find item")
+}
 
-		item := &CRDTItem{
-			LV:          opLv,
-			OriginLeft:  originLeft,
-			OriginRight: originRight,
-			Deleted:     false,
-			CurState:    StateInserted,
+func deleteElement[T any](doc *CRDTDoc, elem *CRDTElement, snapshot *[]T, opLv LV) []Patch[T] {
+	elem.Orig.CurState = 1 // Deleted(1)
+	doc.DelTargets[opLv] = elem.Orig.LV
+
+	if elem.Deleted {
+		return nil
+	}
+	elem.Deleted = true
+	shown := elem.Shown
+	elem.Shown = nil
+
+	pos := SnapshotPos(doc, shown)
+	shown.Deleted = true
+	if snapshot != nil {
+		*snapshot = append((*snapshot)[:pos], (*snapshot)[pos+1:]...)
+	}
+	return []Patch[T]{{Pos: pos, DelLen: 1}}
+}
+
+// applyMove inserts a new placement for the element at op.Pos and shows it in
+// the snapshot if it wins over the placement shown so far.
+func applyMove[T any](doc *CRDTDoc, log *OpLog[T], snapshot *[]T, opLv LV) []Patch[T] {
+	op := log.Ops[opLv]
+
+	idx, _ := FindByCurrentPos(doc.Items, op.Pos)
+	for !IsVisible(doc.Items[idx]) {
+		idx++
+	}
+	src := doc.Items[idx]
+
+	elem := src.Elem
+	if elem == nil {
+		elem = &CRDTElement{
+			Orig:       src,
+			Placements: []Placement{newPlacement(doc, log, src)},
+			Deleted:    src.Deleted,
 		}
-		doc.ItemsByLV[opLv] = item
+		if !src.Deleted {
+			elem.Shown = src
+		}
+		src.Elem = elem
+	}
+
+	// The destination is a position in the document without the element,
+	// so hide it while looking it up.
+	elem.Orig.CurState++
+	idx, endPos := FindByCurrentPos(doc.Items, op.To())
+	item := &CRDTItem{
+		LV:       opLv,
+		Deleted:  true, // Until it wins below
+		CurState: StateInserted,
+		Elem:     elem,
+	}
+	setOrigins(doc, item, idx)
+	elem.Orig.CurState--
 
-		Integrate(doc, log, item, idx, endPos, snapshot)
+	doc.ItemsByLV[opLv] = item
+	to := Integrate(doc, log, item, idx, endPos, snapshot)
+	placement := newPlacement(doc, log, item)
+	elem.Placements = append(elem.Placements, placement)
+
+	if elem.Deleted || placement.Before(elem.placement(elem.Shown)) {
+		return nil
+	}
+
+	shown := elem.Shown
+	from := SnapshotPos(doc, shown)
+	shown.Deleted = true
+	item.Deleted = false
+	elem.Shown = item
+	if from < to {
+		to--
 	}
+
+	var content T
+	if snapshot != nil {
+		content = (*snapshot)[from]
+		*snapshot = append((*snapshot)[:from], (*snapshot)[from+1:]...)
+		*snapshot = append((*snapshot)[:to], append([]T{content}, (*snapshot)[to:]...)...)
+	}
+	return []Patch[T]{{Pos: from, DelLen: 1}, {Pos: to, Content: []T{content}}}
 }
-func Do1Operation[T any](doc *CRDTDoc, log *OpLog[T], lv LV, snapshot *[]T) {
+
+func Do1Operation[T any](doc *CRDTDoc, log *OpLog[T], lv LV, snapshot *[]T) []Patch[T] {
 	//func Do1Operation[T any](doc *CRDTDoc, log *OpLog[T], lv LV, snapshot *bxtree.BxTree[T]) {
 	op := log.Ops[lv]
 	diffRes := Diff(log, doc.CurrentVersion, op.Parents)
@@ -498,8 +897,9 @@ func Do1Operation[T any](doc *CRDTDoc, log *OpLog[T], lv LV, snapshot *[]T) {
 		Advance(doc, log, i)
 	}
 
-	Apply(doc, log, snapshot, lv)
+	patches := Apply(doc, log, snapshot, lv)
 	doc.CurrentVersion = []LV{lv}
+	return patches
 }
 
 func Checkout[T any](log *OpLog[T]) []T {
@@ -677,7 +1077,35 @@ func NewBranch[T any]() *Branch[T] {
 	}
 }
 
-func CheckoutFancy[T any](log *OpLog[T], branch *Branch[T], mergeFrontier []LV) {
+// Patch describes an edit to a snapshot: DelLen items are removed at Pos, then
+// Content is inserted there.
+type Patch[T any] struct {
+	Pos     int
+	DelLen  int
+	Content []T
+}
+
+// appendPatch appends p to patches, merging it into the last patch when p
+// continues it (typing forward or deleting forward).
+func appendPatch[T any](patches []Patch[T], p Patch[T]) []Patch[T] {
+	if n := len(patches); n > 0 {
+		last := &patches[n-1]
+		end := last.Pos + len(last.Content)
+		if p.DelLen == 0 && p.Pos == end {
+			last.Content = append(last.Content, p.Content...)
+			return patches
+		}
+		if len(p.Content) == 0 && p.Pos == end {
+			last.DelLen += p.DelLen
+			return patches
+		}
+	}
+	return append(patches, p)
+}
+
+// CheckoutFancy merges mergeFrontier into the branch and returns the patches
+// that were applied to its snapshot, in order.
+func CheckoutFancy[T any](log *OpLog[T], branch *Branch[T], mergeFrontier []LV) []Patch[T] {
 	if mergeFrontier == nil {
 		mergeFrontier = log.Frontier
 	}
@@ -708,7 +1136,7 @@ func CheckoutFancy[T any](log *OpLog[T], branch *Branch[T], mergeFrontier []LV)
 			OriginLeft:  -1,
 			OriginRight: -1,
 		}
-		doc.Items = append(doc.Items, item)
+		insertItem(doc, len(doc.Items), item)
 		doc.ItemsByLV[item.LV] = item
 	}
 
@@ -718,65 +1146,81 @@ func CheckoutFancy[T any](log *OpLog[T], branch *Branch[T], mergeFrontier []LV)
 	}
 
 	// Process B-only ops (modify doc state and branch snapshot)
+	var patches []Patch[T]
 	for _, lv := range visit.BOnlyOps {
-		Do1Operation(doc, log, lv, &branch.Snapshot)
+		for _, p := range Do1Operation(doc, log, lv, &branch.Snapshot) {
+			patches = appendPatch(patches, p)
+		}
 		//Do1Operation(doc, log, lv, branch.Snapshot)
 		op := log.Ops[lv]
 		branch.Frontier = AdvanceFrontier(branch.Frontier, lv, op.Parents)
 	}
+	return patches
 }
 
 // ==========================================
 // Main Wrapper Class
 // ==========================================
 
-type CRDTDocument struct {
-	OpLog  *OpLog[rune]
+// Document is a replica of a sequence of T, edited locally with Ins and Del
+// and synchronized with other replicas through MergeFrom.
+type Document[T any] struct {
+	OpLog  *OpLog[T]
 	Agent  int
-	Branch *Branch[rune]
+	Branch *Branch[T]
+
+	replay    *objReplay[T] // Item order for anchoring marks, built on first use
+	registers *registers[T] // Heads of the keys set, built on first use
+	listeners documentListeners[T]
 }
 
-func NewCRDTDocument(agent int) *CRDTDocument {
-	return &CRDTDocument{
-		OpLog:  NewOpLog[rune](),
+func NewDocument[T any](agent int) *Document[T] {
+	return &Document[T]{
+		OpLog:  NewOpLog[T](),
 		Agent:  agent,
-		Branch: NewBranch[rune](),
+		Branch: NewBranch[T](),
 	}
 }
 
-func (doc *CRDTDocument) Check() {
-	actualDoc := Checkout(doc.OpLog)
-	s1 := fmt.Sprint(actualDoc)
-	s2 := doc.GetString()
-	if s1 != s2 {
-		panic("Document out of sync: " + s1 + " vs " + s2)
+// NewDocumentFromOpLog creates a document for agent around an existing oplog,
+// checking out its current content.
+func NewDocumentFromOpLog[T any](agent int, log *OpLog[T]) *Document[T] {
+	doc := &Document[T]{
+		OpLog:  log,
+		Agent:  agent,
+		Branch: NewBranch[T](),
 	}
+	CheckoutFancy(doc.OpLog, doc.Branch, doc.OpLog.Frontier)
+	return doc
 }
 
-func (doc *CRDTDocument) Ins(pos int, text string) {
-	chars := []rune{}
-	for _, r := range text {
-		chars = append(chars, r)
-	}
-	LocalInsert(doc.OpLog, doc.Agent, pos, chars)
+func (doc *Document[T]) Ins(pos int, content []T) {
+	before := len(doc.OpLog.Ops)
+	LocalInsert(doc.OpLog, doc.Agent, pos, content)
 
 	// Splice snapshot
 	// snapshot.splice(pos, 0, ...inserted)
-	doc.Branch.Snapshot = append(doc.Branch.Snapshot, chars...)
-	copy(doc.Branch.Snapshot[pos+len(chars):], doc.Branch.Snapshot[pos:len(doc.Branch.Snapshot)-len(chars)])
-	copy(doc.Branch.Snapshot[pos:], chars)
-	//err := doc.Branch.Snapshot.InsertRange(pos, chars)
+	doc.Branch.Snapshot = append(doc.Branch.Snapshot, content...)
+	copy(doc.Branch.Snapshot[pos+len(content):], doc.Branch.Snapshot[pos:len(doc.Branch.Snapshot)-len(content)])
+	copy(doc.Branch.Snapshot[pos:], content)
+	//err := doc.Branch.Snapshot.InsertRange(pos, content)
 	//if err != nil {
-	//	println(doc.Branch.Snapshot.Size(), pos, len(chars))
+	//	println(doc.Branch.Snapshot.Size(), pos, len(content))
 	//	panic("Snapshot insert failed")
 	//}
 
 	// Copy frontier
 	doc.Branch.Frontier = make([]LV, len(doc.OpLog.Frontier))
 	copy(doc.Branch.Frontier, doc.OpLog.Frontier)
+
+	if len(content) > 0 {
+		doc.emitOps(&doc.listeners.localOps, before)
+		doc.emitPatches([]Patch[T]{{Pos: pos, Content: slices.Clone(content)}})
+	}
 }
 
-func (doc *CRDTDocument) Del(pos int, delLen int) {
+func (doc *Document[T]) Del(pos int, delLen int) {
+	before := len(doc.OpLog.Ops)
 	LocalDelete(doc.OpLog, doc.Agent, pos, delLen)
 
 	// Splice snapshot remove
@@ -789,6 +1233,126 @@ func (doc *CRDTDocument) Del(pos int, delLen int) {
 
 	doc.Branch.Frontier = make([]LV, len(doc.OpLog.Frontier))
 	copy(doc.Branch.Frontier, doc.OpLog.Frontier)
+
+	if delLen > 0 {
+		doc.emitOps(&doc.listeners.localOps, before)
+		doc.emitPatches([]Patch[T]{{Pos: pos, DelLen: delLen}})
+	}
+}
+
+// Move moves the item at from so that it ends up at index to. Concurrent moves
+// of the same item never duplicate it: one of them wins on every replica.
+// It panics, without adding an op, if either index is out of range.
+func (doc *Document[T]) Move(from int, to int) {
+	if from < 0 || from >= doc.Len() || to < 0 || to >= doc.Len() {
+		panic(fmt.Sprintf("Invalid move from %d to %d in content of length %d", from, to, doc.Len()))
+	}
+	before := len(doc.OpLog.Ops)
+	LocalMove(doc.OpLog, doc.Agent, from, to)
+
+	item := doc.Branch.Snapshot[from]
+	doc.Branch.Snapshot = slices.Delete(doc.Branch.Snapshot, from, from+1)
+	doc.Branch.Snapshot = slices.Insert(doc.Branch.Snapshot, to, item)
+
+	doc.Branch.Frontier = make([]LV, len(doc.OpLog.Frontier))
+	copy(doc.Branch.Frontier, doc.OpLog.Frontier)
+
+	doc.emitOps(&doc.listeners.localOps, before)
+	doc.emitPatches([]Patch[T]{{Pos: from, DelLen: 1}, {Pos: to, Content: []T{item}}})
+}
+
+func (doc *Document[T]) Len() int {
+	return len(doc.Branch.Snapshot)
+}
+
+// Items returns a copy of the current content.
+func (doc *Document[T]) Items() []T {
+	return slices.Clone(doc.Branch.Snapshot)
+}
+
+func (doc *Document[T]) MergeFrom(other *Document[T]) {
+	doc.mergeOpLog(other.OpLog)
+}
+
+func (doc *Document[T]) mergeOpLog(src *OpLog[T]) {
+	before := len(doc.OpLog.Ops)
+	MergeInto(doc.OpLog, src)
+	doc.checkoutRemote(before)
+}
+
+// applyWireOps merges ops received from a peer. Ops that were accepted before
+// an error are still checked out, unless they do not fit the document: then
+// the oplog is rolled back to where it was and the branch is rebuilt.
+func (doc *Document[T]) applyWireOps(ops []WireOp[T]) error {
+	before := len(doc.OpLog.Ops)
+	err := PushWireOps(doc.OpLog, ops)
+	if before == len(doc.OpLog.Ops) {
+		return err
+	}
+	patches, checkoutErr := tryCheckout(doc.OpLog, doc.Branch)
+	if checkoutErr != nil {
+		doc.OpLog.truncate(before)
+		doc.replay = nil
+		doc.registers = nil
+		doc.Branch = NewBranch[T]()
+		CheckoutFancy(doc.OpLog, doc.Branch, doc.OpLog.Frontier)
+		return checkoutErr
+	}
+
+	doc.emitOps(&doc.listeners.remoteOps, before)
+	doc.emitPatches(patches)
+	return err
+}
+
+// checkoutRemote brings the branch up to date with the ops pushed to the
+// oplog since before, and notifies subscribers.
+func (doc *Document[T]) checkoutRemote(before int) {
+	if before == len(doc.OpLog.Ops) {
+		return
+	}
+	patches := CheckoutFancy(doc.OpLog, doc.Branch, doc.OpLog.Frontier)
+
+	doc.emitOps(&doc.listeners.remoteOps, before)
+	doc.emitPatches(patches)
+}
+
+func (doc *Document[T]) Reset() {
+	doc.OpLog = NewOpLog[T]()
+	doc.Branch = NewBranch[T]()
+	doc.replay = nil
+	doc.registers = nil
+}
+
+// CRDTDocument is the text specialization of Document: positions are rune
+// indices and content is passed as strings.
+type CRDTDocument struct {
+	*Document[rune]
+
+	widths        *bxtree.BxTree[runeWidths] // One entry per rune of the text, see newTextIndex
+	unitListeners [numPosUnits]listenerList[TextPatch]
+}
+
+func NewCRDTDocument(agent int) *CRDTDocument {
+	return newCRDTDocument(NewDocument[rune](agent))
+}
+
+// NewCRDTDocumentFromOpLog creates a document for agent around an existing
+// oplog, checking out its current text.
+func NewCRDTDocumentFromOpLog(agent int, log *OpLog[rune]) *CRDTDocument {
+	return newCRDTDocument(NewDocumentFromOpLog(agent, log))
+}
+
+func (doc *CRDTDocument) Check() {
+	actualDoc := Checkout(doc.OpLog)
+	s1 := fmt.Sprint(actualDoc)
+	s2 := doc.GetString()
+	if s1 != s2 {
+		panic("Document out of sync: " + s1 + " vs " + s2)
+	}
+}
+
+func (doc *CRDTDocument) Ins(pos int, text string) {
+	doc.Document.Ins(pos, []rune(text))
 }
 
 func (doc *CRDTDocument) GetString() string {
@@ -803,11 +1367,5 @@ func (doc *CRDTDocument) GetString() string {
 }
 
 func (doc *CRDTDocument) MergeFrom(other *CRDTDocument) {
-	MergeInto(doc.OpLog, other.OpLog)
-	CheckoutFancy(doc.OpLog, doc.Branch, doc.OpLog.Frontier)
-}
-
-func (doc *CRDTDocument) Reset() {
-	doc.OpLog = NewOpLog[rune]()
-	doc.Branch = NewBranch[rune]()
+	doc.Document.MergeFrom(other.Document)
 }
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ==========================================
+// JSON Documents
+// ==========================================
+
+var (
+	ErrPathNotFound = errors.New("path not found")
+	ErrWrongKind    = errors.New("wrong kind of object")
+	ErrOutOfRange   = errors.New("index out of range")
+)
+
+// ObjId identifies a container of a JSON document: the LV of the op that
+// created it plus one, so that the zero value is the root map.
+type ObjId int
+
+const RootObj ObjId = 0
+
+func objOf(lv LV) ObjId {
+	return ObjId(lv + 1)
+}
+
+type ObjKind string
+
+const (
+	ObjMap  ObjKind = "map"
+	ObjList ObjKind = "list"
+	ObjText ObjKind = "text"
+)
+
+// Path addresses a value from the root: string elements are map keys, int
+// elements are list indices.
+type Path []any
+
+// JSONDocument is a tree of maps, lists and texts stored in one oplog. Maps
+// are the registers of set ops, lists and texts are sequences with an item
+// order kept per container. Two replicas that create a container under the same key
+// concurrently end up with the winner of the key; the content of the other
+// one is kept in the oplog but no longer reachable.
+//
+// Values should be JSON values: numbers come back as float64 once they have
+// been through the wire format.
+type JSONDocument struct {
+	OpLog *OpLog[any]
+	Agent int
+
+	registers *registers[any]           // Heads of the keys of every map
+	seqs      map[ObjId]*objReplay[any] // Item order of every list and text
+	next      int                       // ops before next have been applied to seqs
+}
+
+func NewJSONDocument(agent int) *JSONDocument {
+	return NewJSONDocumentFromOpLog(agent, NewOpLog[any]())
+}
+
+func NewJSONDocumentFromOpLog(agent int, log *OpLog[any]) *JSONDocument {
+	return &JSONDocument{OpLog: log, Agent: agent}
+}
+
+// catchUp applies the ops pushed since the last call to the state of the
+// containers they belong to.
+func (doc *JSONDocument) catchUp() {
+	if doc.registers == nil {
+		doc.registers = newRegisters[any]()
+		doc.seqs = map[ObjId]*objReplay[any]{}
+	}
+	doc.registers.catchUp(doc.OpLog)
+	for ; doc.next < len(doc.OpLog.Ops); doc.next++ {
+		op := doc.OpLog.Ops[doc.next]
+		if op.Type == OpTypeSet {
+			continue
+		}
+		seq := doc.seqs[op.Obj()]
+		if seq == nil {
+			seq = newObjReplay[any](op.Obj(), false)
+			doc.seqs[op.Obj()] = seq
+		}
+		seq.apply(doc.OpLog, LV(doc.next))
+	}
+}
+
+// mapEntries returns the op holding the value of each key of the map obj.
+func (doc *JSONDocument) mapEntries(obj ObjId) map[string]LV {
+	doc.catchUp()
+	entries := map[string]LV{}
+	for key, lvs := range doc.registers.heads[obj] {
+		lv := lvs[len(lvs)-1]
+		if op := doc.OpLog.Ops[lv]; op.Value() != nil || op.Make() != "" {
+			entries[key] = lv
+		}
+	}
+	return entries
+}
+
+// listItems returns the insert op of each item of the list or text obj.
+func (doc *JSONDocument) listItems(obj ObjId) []LV {
+	doc.catchUp()
+	lvs := []LV{}
+	seq := doc.seqs[obj]
+	if seq == nil {
+		return lvs
+	}
+	for _, item := range seq.at(doc.OpLog).Items {
+		if !IsVisible(item) {
+			continue
+		}
+		if item.Elem != nil {
+			item = item.Elem.Orig
+		}
+		lvs = append(lvs, item.LV)
+	}
+	return lvs
+}
+
+func (doc *JSONDocument) child(obj ObjId, kind ObjKind, elem any) (LV, error) {
+	switch key := elem.(type) {
+	case string:
+		if kind != ObjMap {
+			return 0, ErrWrongKind
+		}
+		lv, ok := doc.mapEntries(obj)[key]
+		if !ok {
+			return 0, ErrPathNotFound
+		}
+		return lv, nil
+	case int:
+		if kind != ObjList {
+			return 0, ErrWrongKind
+		}
+		items := doc.listItems(obj)
+		if key < 0 || key >= len(items) {
+			return 0, ErrOutOfRange
+		}
+		return items[key], nil
+	default:
+		return 0, fmt.Errorf("%w: %T in path", ErrPathNotFound, elem)
+	}
+}
+
+// Resolve returns the container at path.
+func (doc *JSONDocument) Resolve(path Path) (ObjId, ObjKind, error) {
+	obj, kind := RootObj, ObjMap
+	for i, elem := range path {
+		lv, err := doc.child(obj, kind, elem)
+		if err != nil {
+			return 0, "", fmt.Errorf("%w: %v", err, path[:i+1])
+		}
+		op := doc.OpLog.Ops[lv]
+		if op.Make() == "" {
+			return 0, "", fmt.Errorf("%w: %v is not an object", ErrWrongKind, path[:i+1])
+		}
+		obj, kind = objOf(lv), op.Make()
+	}
+	return obj, kind, nil
+}
+
+func (doc *JSONDocument) resolveKind(path Path, kinds ...ObjKind) (ObjId, ObjKind, error) {
+	obj, kind, err := doc.Resolve(path)
+	if err != nil {
+		return 0, "", err
+	}
+	for _, k := range kinds {
+		if k == kind {
+			return obj, kind, nil
+		}
+	}
+	return 0, "", fmt.Errorf("%w: %v is a %s", ErrWrongKind, path, kind)
+}
+
+// Set writes value under the last key of path, in the map the rest of path
+// leads to. A nil value deletes the key; an ObjKind value creates an empty
+// container.
+func (doc *JSONDocument) Set(path Path, value any) error {
+	if len(path) == 0 {
+		return fmt.Errorf("%w: cannot set the root", ErrWrongKind)
+	}
+	key, ok := path[len(path)-1].(string)
+	if !ok {
+		return fmt.Errorf("%w: %v does not end with a key", ErrWrongKind, path)
+	}
+	obj, _, err := doc.resolveKind(path[:len(path)-1], ObjMap)
+	if err != nil {
+		return err
+	}
+
+	op := Op[any]{Type: OpTypeSet, Ext: &OpExt{Obj: obj, Key: key}}
+	if kind, ok := value.(ObjKind); ok {
+		op.Ext.Make = kind
+	} else {
+		op.Ext.Value = value
+	}
+	doc.OpLog.PushLocalOp(doc.Agent, op)
+	return nil
+}
+
+// Insert inserts values at index in the list at path. ObjKind values create
+// empty containers.
+func (doc *JSONDocument) Insert(path Path, index int, values ...any) error {
+	obj, _, err := doc.resolveKind(path, ObjList)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index > len(doc.listItems(obj)) {
+		return fmt.Errorf("%w: %d in %v", ErrOutOfRange, index, path)
+	}
+
+	for i, value := range values {
+		op := Op[any]{Type: OpTypeIns, Pos: index + i, Ext: &OpExt{Obj: obj}}
+		if kind, ok := value.(ObjKind); ok {
+			op.Ext.Make = kind
+		} else {
+			op.Content = value
+		}
+		doc.OpLog.PushLocalOp(doc.Agent, op)
+	}
+	return nil
+}
+
+// InsertText inserts text at pos in the text at path. Positions are rune
+// indices.
+func (doc *JSONDocument) InsertText(path Path, pos int, text string) error {
+	obj, _, err := doc.resolveKind(path, ObjText)
+	if err != nil {
+		return err
+	}
+	if pos < 0 || pos > len(doc.listItems(obj)) {
+		return fmt.Errorf("%w: %d in %v", ErrOutOfRange, pos, path)
+	}
+
+	// One string per rune, so the content survives the wire format
+	for i, r := range []rune(text) {
+		doc.OpLog.PushLocalOp(doc.Agent, Op[any]{Type: OpTypeIns, Pos: pos + i, Content: string(r), Ext: &OpExt{Obj: obj}})
+	}
+	return nil
+}
+
+// Delete removes n items of the list or text at path, starting at index.
+func (doc *JSONDocument) Delete(path Path, index int, n int) error {
+	obj, _, err := doc.resolveKind(path, ObjList, ObjText)
+	if err != nil {
+		return err
+	}
+	if index < 0 || n < 0 || index+n > len(doc.listItems(obj)) {
+		return fmt.Errorf("%w: [%d, %d) in %v", ErrOutOfRange, index, index+n, path)
+	}
+
+	for range n {
+		doc.OpLog.PushLocalOp(doc.Agent, Op[any]{Type: OpTypeDel, Pos: index, Ext: &OpExt{Obj: obj}})
+	}
+	return nil
+}
+
+// Get returns the value at path. Containers are returned as map[string]any,
+// []any and string.
+func (doc *JSONDocument) Get(path Path) (any, error) {
+	if len(path) == 0 {
+		return doc.read(RootObj, ObjMap), nil
+	}
+	obj, kind, err := doc.Resolve(path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	lv, err := doc.child(obj, kind, path[len(path)-1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", err, path)
+	}
+	return doc.value(lv), nil
+}
+
+// Value returns the whole document.
+func (doc *JSONDocument) Value() map[string]any {
+	return doc.read(RootObj, ObjMap).(map[string]any)
+}
+
+func (doc *JSONDocument) value(lv LV) any {
+	op := doc.OpLog.Ops[lv]
+	switch {
+	case op.Make() != "":
+		return doc.read(objOf(lv), op.Make())
+	case op.Type == OpTypeSet:
+		return op.Value()
+	default:
+		return op.Content
+	}
+}
+
+func (doc *JSONDocument) read(obj ObjId, kind ObjKind) any {
+	switch kind {
+	case ObjMap:
+		values := map[string]any{}
+		for key, lv := range doc.mapEntries(obj) {
+			values[key] = doc.value(lv)
+		}
+		return values
+	case ObjText:
+		var sb strings.Builder
+		for _, lv := range doc.listItems(obj) {
+			s, _ := doc.OpLog.Ops[lv].Content.(string)
+			sb.WriteString(s)
+		}
+		return sb.String()
+	default:
+		values := []any{}
+		for _, lv := range doc.listItems(obj) {
+			values = append(values, doc.value(lv))
+		}
+		return values
+	}
+}
+
+// Keys returns the keys of the map at path, sorted.
+func (doc *JSONDocument) Keys(path Path) ([]string, error) {
+	obj, _, err := doc.resolveKind(path, ObjMap)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(doc.mapEntries(obj))), nil
+}
+
+func (doc *JSONDocument) MergeFrom(other *JSONDocument) {
+	MergeInto(doc.OpLog, other.OpLog)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONDocumentMerge(t *testing.T) {
+	a := NewJSONDocument(1)
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(a.Set(Path{"title"}, ObjText))
+	must(a.InsertText(Path{"title"}, 0, "Groceries"))
+	must(a.Set(Path{"items"}, ObjList))
+	must(a.Insert(Path{"items"}, 0, ObjMap, ObjMap))
+	must(a.Set(Path{"items", 0, "name"}, "milk"))
+	must(a.Set(Path{"items", 1, "name"}, "eggs"))
+
+	b := NewJSONDocument(2)
+	b.MergeFrom(a)
+
+	// Concurrent edits in different containers
+	must(a.InsertText(Path{"title"}, 9, " list"))
+	must(a.Set(Path{"items", 0, "done"}, true))
+	must(b.InsertText(Path{"title"}, 0, "Weekly "))
+	must(b.Insert(Path{"items"}, 2, ObjMap))
+	must(b.Set(Path{"items", 2, "name"}, "bread"))
+	must(b.Delete(Path{"items"}, 1, 1))
+
+	a.MergeFrom(b)
+	b.MergeFrom(a)
+
+	expected := map[string]any{
+		"title": "Weekly Groceries list",
+		"items": []any{
+			map[string]any{"name": "milk", "done": true},
+			map[string]any{"name": "bread"},
+		},
+	}
+	if !reflect.DeepEqual(a.Value(), expected) || !reflect.DeepEqual(b.Value(), expected) {
+		t.Fatalf("Expected %v, got %v / %v", expected, a.Value(), b.Value())
+	}
+
+	name, err := a.Get(Path{"items", 1, "name"})
+	if err != nil || name != "bread" {
+		t.Fatalf("Get returned %v, %v", name, err)
+	}
+	keys, _ := a.Keys(Path{"items", 0})
+	if !reflect.DeepEqual(keys, []string{"done", "name"}) {
+		t.Fatalf("Unexpected keys %v", keys)
+	}
+
+	// Replaying a single container leaves the others alone
+	if got := Checkout(a.OpLog); len(got) != 0 {
+		t.Fatalf("Root sequence should be empty, got %v", got)
+	}
+}
+
+func TestJSONDocumentErrors(t *testing.T) {
+	doc := NewJSONDocument(1)
+	doc.Set(Path{"n"}, 1)
+	doc.Set(Path{"list"}, ObjList)
+
+	if _, err := doc.Get(Path{"missing"}); !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("Expected ErrPathNotFound, got %v", err)
+	}
+	if err := doc.Set(Path{"n", "x"}, 2); !errors.Is(err, ErrWrongKind) {
+		t.Fatalf("Expected ErrWrongKind, got %v", err)
+	}
+	if err := doc.InsertText(Path{"list"}, 0, "x"); !errors.Is(err, ErrWrongKind) {
+		t.Fatalf("Expected ErrWrongKind, got %v", err)
+	}
+	if err := doc.Insert(Path{"list"}, 1, "x"); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("Expected ErrOutOfRange, got %v", err)
+	}
+}
+
+func TestJSONDocumentWireFormat(t *testing.T) {
+	doc := NewJSONDocument(1)
+	doc.Set(Path{"tags"}, ObjList)
+	doc.Insert(Path{"tags"}, 0, "a", ObjText)
+	doc.InsertText(Path{"tags", 1}, 0, "hé")
+	doc.Set(Path{"count"}, 3)
+
+	data, err := json.Marshal(OpsSince(doc.OpLog, nil))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var ops []WireOp[any]
+	if err := json.Unmarshal(data, &ops); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	log := NewOpLog[any]()
+	if err := PushWireOps(log, ops); err != nil {
+		t.Fatalf("PushWireOps failed: %v", err)
+	}
+
+	expected := map[string]any{"tags": []any{"a", "hé"}, "count": float64(3)}
+	if got := NewJSONDocumentFromOpLog(2, log).Value(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+
+	// Ops into a container the receiver does not have are rejected
+	bad := []WireOp[any]{{Type: OpTypeIns, Content: "x", Obj: &Id{Agent: 9, Seq: 0}, Id: Id{Agent: 3, Seq: 0}}}
+	if err := PushWireOps(log, bad); !errors.Is(err, ErrUnknownObject) {
+		t.Fatalf("Expected ErrUnknownObject, got %v", err)
+	}
+}
+
+// naiveJSONValue reads the document by replaying every container from the
+// start of the oplog, the reference for the state kept by JSONDocument.
+func naiveJSONValue(log *OpLog[any], obj ObjId, kind ObjKind) any {
+	value := func(lv LV) any {
+		op := log.Ops[lv]
+		switch {
+		case op.Make() != "":
+			return naiveJSONValue(log, objOf(lv), op.Make())
+		case op.Type == OpTypeSet:
+			return op.Value()
+		default:
+			return op.Content
+		}
+	}
+	if kind == ObjMap {
+		values := map[string]any{}
+		for key, lvs := range registerHeads(log, obj) {
+			lv := lvs[len(lvs)-1]
+			if op := log.Ops[lv]; op.Value() != nil || op.Make() != "" {
+				values[key] = value(lv)
+			}
+		}
+		return values
+	}
+	crdt, _ := replayObj(log, obj)
+	var sb strings.Builder
+	values := []any{}
+	for _, item := range crdt.Items {
+		if IsVisible(item) {
+			s, _ := log.Ops[item.LV].Content.(string)
+			sb.WriteString(s)
+			values = append(values, value(item.LV))
+		}
+	}
+	if kind == ObjText {
+		return sb.String()
+	}
+	return values
+}
+
+func TestJSONDocumentRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	docs := []*JSONDocument{NewJSONDocument(1), NewJSONDocument(2), NewJSONDocument(3)}
+	docs[0].Set(Path{"list"}, ObjList)
+	docs[0].Set(Path{"text"}, ObjText)
+	for _, doc := range docs[1:] {
+		doc.MergeFrom(docs[0])
+	}
+
+	for i := 0; i < 300; i++ {
+		doc := docs[r.Intn(len(docs))]
+		n := 0
+		if items, err := doc.Get(Path{"list"}); err == nil {
+			n = len(items.([]any))
+		}
+		// Edits may fail once a concurrent set has replaced a container
+		switch r.Intn(8) {
+		case 0:
+			doc.MergeFrom(docs[r.Intn(len(docs))])
+		case 1:
+			doc.Insert(Path{"list"}, r.Intn(n+1), ObjMap)
+		case 2:
+			doc.Insert(Path{"list"}, r.Intn(n+1), fmt.Sprint(i))
+		case 3:
+			if n > 0 {
+				doc.Delete(Path{"list"}, r.Intn(n), 1)
+			}
+		case 4:
+			if n > 0 {
+				doc.Set(Path{"list", r.Intn(n), "k"}, fmt.Sprint(i))
+			}
+		case 5:
+			text, _ := doc.Get(Path{"text"})
+			s, _ := text.(string)
+			doc.InsertText(Path{"text"}, r.Intn(len([]rune(s))+1), "ab")
+		case 6:
+			doc.Set(Path{fmt.Sprint("k", r.Intn(3))}, fmt.Sprint(i))
+		default:
+			if r.Intn(10) == 0 {
+				doc.Set(Path{"list"}, ObjList)
+			}
+		}
+
+		for _, doc := range docs {
+			expected := naiveJSONValue(doc.OpLog, RootObj, ObjMap)
+			if got := doc.Value(); !reflect.DeepEqual(got, expected) {
+				t.Fatalf("Iteration %d: got %v, expected %v", i, got, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ==========================================
+// Lines and Columns
+// ==========================================
+
+// LineCol is a position as editors show it. Lines are 0-based and split on
+// '\n'; columns are counted in the unit the caller asks for.
+type LineCol struct {
+	Line int
+	Col  int
+}
+
+// LineCount returns the number of lines, which is one more than the number
+// of newlines.
+func (doc *CRDTDocument) LineCount() int {
+	return doc.widths.Sum(measureNewlines) + 1
+}
+
+// lineStart returns the rune position where line starts.
+func (doc *CRDTDocument) lineStart(line int) (int, error) {
+	if line < 0 || line >= doc.LineCount() {
+		return 0, fmt.Errorf("%w: line %d of %d", ErrInvalidPos, line, doc.LineCount())
+	}
+	if line == 0 {
+		return 0, nil
+	}
+	newline, _, err := doc.widths.SeekBy(measureNewlines, line-1)
+	if err != nil {
+		panic("Text index out of sync")
+	}
+	return newline + 1, nil
+}
+
+// lineEnd returns the rune position of the newline ending line, or the end
+// of the text for the last line.
+func (doc *CRDTDocument) lineEnd(line int) int {
+	if line == doc.LineCount()-1 {
+		return doc.widths.Size()
+	}
+	end, _ := doc.lineStart(line + 1)
+	return end - 1
+}
+
+// LineCol converts a position in unit to a line and a column in unit.
+func (doc *CRDTDocument) LineCol(unit PosUnit, pos int) (LineCol, error) {
+	n, err := doc.toRunes(unit, pos)
+	if err != nil {
+		return LineCol{}, err
+	}
+	line, err := doc.widths.PrefixSum(measureNewlines, n)
+	if err != nil {
+		panic("Text index out of sync")
+	}
+	start, _ := doc.lineStart(line)
+	return LineCol{Line: line, Col: doc.fromRunes(unit, n) - doc.fromRunes(unit, start)}, nil
+}
+
+// PosOf converts a line and a column in unit to a position in unit. The
+// column may be at most the length of the line.
+func (doc *CRDTDocument) PosOf(unit PosUnit, lc LineCol) (int, error) {
+	n, err := doc.lineColToRunes(unit, lc)
+	if err != nil {
+		return 0, err
+	}
+	return doc.fromRunes(unit, n), nil
+}
+
+func (doc *CRDTDocument) lineColToRunes(unit PosUnit, lc LineCol) (int, error) {
+	start, err := doc.lineStart(lc.Line)
+	if err != nil {
+		return 0, err
+	}
+	n, err := doc.toRunes(unit, doc.fromRunes(unit, start)+lc.Col)
+	if err != nil || lc.Col < 0 || n > doc.lineEnd(lc.Line) {
+		return 0, fmt.Errorf("%w: column %d of line %d", ErrInvalidPos, lc.Col, lc.Line)
+	}
+	return n, nil
+}
+
+// Line returns the text of line, without its newline.
+func (doc *CRDTDocument) Line(line int) (string, error) {
+	start, err := doc.lineStart(line)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, r := range doc.Branch.Snapshot[start:doc.lineEnd(line)] {
+		sb.WriteRune(r)
+	}
+	return sb.String(), nil
+}
+
+// InsAtLineCol inserts text at a line and a column in unit.
+func (doc *CRDTDocument) InsAtLineCol(unit PosUnit, lc LineCol, text string) error {
+	n, err := doc.lineColToRunes(unit, lc)
+	if err != nil {
+		return err
+	}
+	doc.Ins(n, text)
+	return nil
+}
+
+// DelLineColRange deletes the text between two line/column positions, e.g.
+// the range of an LSP text edit.
+func (doc *CRDTDocument) DelLineColRange(unit PosUnit, from LineCol, to LineCol) error {
+	start, err := doc.lineColToRunes(unit, from)
+	if err != nil {
+		return err
+	}
+	end, err := doc.lineColToRunes(unit, to)
+	if err != nil {
+		return err
+	}
+	if end < start {
+		return fmt.Errorf("%w: range ends before it starts", ErrInvalidPos)
+	}
+	doc.Del(start, end-start)
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestLineCol(t *testing.T) {
+	doc := NewCRDTDocument(1)
+	doc.Ins(0, "first\nsé😀nd\n\nlast")
+
+	if doc.LineCount() != 4 {
+		t.Fatalf("Expected 4 lines, got %d", doc.LineCount())
+	}
+	if line, _ := doc.Line(1); line != "sé😀nd" {
+		t.Fatalf("Unexpected line %q", line)
+	}
+
+	// The 'n' of the second line, in each unit
+	for _, c := range []struct {
+		unit   PosUnit
+		pos    int
+		column int
+	}{{UnitRune, 9, 3}, {UnitUTF8, 13, 7}, {UnitUTF16, 10, 4}} {
+		lc, err := doc.LineCol(c.unit, c.pos)
+		if err != nil || lc != (LineCol{Line: 1, Col: c.column}) {
+			t.Fatalf("LineCol(%s, %d) = %v, %v", c.unit, c.pos, lc, err)
+		}
+		pos, err := doc.PosOf(c.unit, lc)
+		if err != nil || pos != c.pos {
+			t.Fatalf("PosOf(%s, %v) = %d, %v", c.unit, lc, pos, err)
+		}
+	}
+
+	if _, err := doc.PosOf(UnitRune, LineCol{Line: 0, Col: 6}); !errors.Is(err, ErrInvalidPos) {
+		t.Fatalf("Expected ErrInvalidPos past the end of a line, got %v", err)
+	}
+	if _, err := doc.PosOf(UnitRune, LineCol{Line: 4}); !errors.Is(err, ErrInvalidPos) {
+		t.Fatalf("Expected ErrInvalidPos past the last line, got %v", err)
+	}
+
+	if err := doc.InsAtLineCol(UnitUTF16, LineCol{Line: 2, Col: 0}, "third"); err != nil {
+		t.Fatalf("InsAtLineCol failed: %v", err)
+	}
+	if err := doc.DelLineColRange(UnitUTF16, LineCol{Line: 0, Col: 5}, LineCol{Line: 1, Col: 2}); err != nil {
+		t.Fatalf("DelLineColRange failed: %v", err)
+	}
+	if doc.GetString() != "first😀nd\nthird\nlast" {
+		t.Fatalf("Unexpected text %q", doc.GetString())
+	}
+}
+
+func TestLineColRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	alphabet := []rune("ab😀\n")
+	doc := NewCRDTDocument(1)
+
+	for i := range 2000 {
+		if doc.Len() == 0 || r.Float64() < 0.6 {
+			doc.Ins(r.Intn(doc.Len()+1), string(alphabet[r.Intn(len(alphabet))]))
+		} else {
+			doc.Del(r.Intn(doc.Len()), 1)
+		}
+		if i%20 != 0 {
+			continue
+		}
+
+		lines := strings.Split(doc.GetString(), "\n")
+		if doc.LineCount() != len(lines) {
+			t.Fatalf("Iteration %d: %d lines, expected %d", i, doc.LineCount(), len(lines))
+		}
+		pos := 0
+		for l, line := range lines {
+			for c := 0; c <= len(utf16.Encode([]rune(line))); c++ {
+				lc := LineCol{Line: l, Col: c}
+				got, err := doc.PosOf(UnitUTF16, lc)
+				if err != nil {
+					// Inside a surrogate pair
+					continue
+				}
+				if got != pos+c {
+					t.Fatalf("Iteration %d: PosOf(%v) = %d, expected %d", i, lc, got, pos+c)
+				}
+				if back, _ := doc.LineCol(UnitUTF16, got); back != lc {
+					t.Fatalf("Iteration %d: LineCol(%d) = %v, expected %v", i, got, back, lc)
+				}
+			}
+			pos += len(utf16.Encode([]rune(line))) + 1
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"cmp"
+	"container/heap"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// ==========================================
+// Formatting Marks
+// ==========================================
+
+// MarkExpand says whether content inserted at the edges of a marked range
+// gets the mark too, e.g. bold usually expands after but links do not.
+type MarkExpand int
+
+const (
+	ExpandNone MarkExpand = iota
+	ExpandBefore
+	ExpandAfter
+	ExpandBoth
+)
+
+// Anchor is a boundary in the item order: just before or just after Item.
+// Item -1 stands for the edges of the document; after it is the start and
+// before it is the end.
+type Anchor struct {
+	Item  LV
+	After bool
+}
+
+var (
+	DocStart = Anchor{Item: -1, After: true}
+	DocEnd   = Anchor{Item: -1, After: false}
+)
+
+// Mark sets Key to Value on every item between Start and End. A nil Value
+// removes the key. When marks overlap, the one with the highest
+// (lamport, agent) wins per key, like concurrent moves.
+type Mark struct {
+	Start Anchor
+	End   Anchor
+	Key   string
+	Value any
+}
+
+func (mark *Mark) remap(f func(LV) LV) *Mark {
+	m := *mark
+	if m.Start.Item != -1 {
+		m.Start.Item = f(m.Start.Item)
+	}
+	if m.End.Item != -1 {
+		m.End.Item = f(m.End.Item)
+	}
+	return &m
+}
+
+func LocalMark[T any](log *OpLog[T], agent int, mark Mark) {
+	log.PushLocalOp(agent, Op[T]{
+		Type: OpTypeMark,
+		Ext:  &OpExt{Mark: &mark},
+	})
+}
+
+// Span is a run of content with the same marks.
+type Span[T any] struct {
+	Content []T
+	Marks   map[string]any
+}
+
+// objReplay is the item order of one container, kept at the frontier of the
+// oplog: ops pushed since the last use are applied on top instead of
+// replaying everything again.
+type objReplay[T any] struct {
+	doc      *CRDTDoc
+	snapshot *[]T // nil when the content is not needed
+	next     int  // ops before next have been applied
+}
+
+func newObjReplay[T any](obj ObjId, withContent bool) *objReplay[T] {
+	r := &objReplay[T]{doc: &CRDTDoc{
+		Items:          []*CRDTItem{},
+		CurrentVersion: []LV{},
+		DelTargets:     make(map[LV]LV),
+		ItemsByLV:      make(map[LV]*CRDTItem),
+		Obj:            obj,
+	}}
+	if withContent {
+		r.snapshot = &[]T{}
+	}
+	return r
+}
+
+// apply runs the op at lv, an op of the container made after every op
+// applied so far.
+func (r *objReplay[T]) apply(log *OpLog[T], lv LV) {
+	Do1Operation(r.doc, log, lv, r.snapshot)
+	r.next = int(lv) + 1
+}
+
+// catchUp applies the ops of the container pushed since the last call and
+// returns the doc at the frontier.
+func (r *objReplay[T]) catchUp(log *OpLog[T]) *CRDTDoc {
+	for lv := r.next; lv < len(log.Ops); lv++ {
+		if log.Ops[lv].Obj() == r.doc.Obj {
+			r.apply(log, LV(lv))
+		}
+	}
+	r.next = len(log.Ops)
+	return r.at(log)
+}
+
+// at moves the doc to the frontier of log: the last op applied may not have
+// seen every other one.
+func (r *objReplay[T]) at(log *OpLog[T]) *CRDTDoc {
+	if slices.Equal(r.doc.CurrentVersion, log.Frontier) {
+		return r.doc
+	}
+	diffRes := Diff(log, r.doc.CurrentVersion, log.Frontier)
+	for _, lv := range diffRes.AOnly {
+		Retreat(r.doc, log, lv)
+	}
+	for _, lv := range diffRes.BOnly {
+		Advance(r.doc, log, lv)
+	}
+	r.doc.CurrentVersion = slices.Clone(log.Frontier)
+	return r.doc
+}
+
+// replayObj runs every op of the container obj, leaving doc at the frontier
+// with the full item order, deleted items included.
+func replayObj[T any](log *OpLog[T], obj ObjId) (*CRDTDoc, []T) {
+	r := newObjReplay[T](obj, true)
+	doc := r.catchUp(log)
+	return doc, *r.snapshot
+}
+
+// visibleLVs returns the LV of the item at each position of the content of
+// doc.
+func visibleLVs(doc *CRDTDoc) []LV {
+	lvs := []LV{}
+	for _, item := range doc.Items {
+		if IsVisible(item) {
+			lvs = append(lvs, item.LV)
+		}
+	}
+	return lvs
+}
+
+// VisibleItems returns the LV of the item at each position of the content.
+func VisibleItems[T any](log *OpLog[T]) []LV {
+	doc, _ := replayObj(log, RootObj)
+	return visibleLVs(doc)
+}
+
+// MarkRange returns the mark covering positions [start, end) of the content
+// of log, with anchors placed according to expand.
+func MarkRange[T any](log *OpLog[T], start int, end int, key string, value any, expand MarkExpand) Mark {
+	doc, _ := replayObj(log, RootObj)
+	return markRange(doc, start, end, key, value, expand)
+}
+
+// markRange is MarkRange for the content of doc.
+func markRange(doc *CRDTDoc, start int, end int, key string, value any, expand MarkExpand) Mark {
+	items := visibleLVs(doc)
+	if start < 0 || end > len(items) || start >= end {
+		panic(fmt.Sprintf("Invalid mark range [%d, %d) in content of length %d", start, end, len(items)))
+	}
+
+	mark := Mark{
+		Start: Anchor{Item: items[start]},
+		End:   Anchor{Item: items[end-1], After: true},
+		Key:   key,
+		Value: value,
+	}
+	if expand == ExpandBefore || expand == ExpandBoth {
+		mark.Start = DocStart
+		if start > 0 {
+			mark.Start = Anchor{Item: items[start-1], After: true}
+		}
+	}
+	if expand == ExpandAfter || expand == ExpandBoth {
+		mark.End = DocEnd
+		if end < len(items) {
+			mark.End = Anchor{Item: items[end]}
+		}
+	}
+	return mark
+}
+
+// Spans returns the content of log split into runs with the same marks.
+func Spans[T any](log *OpLog[T]) []Span[T] {
+	doc, snapshot := replayObj(log, RootObj)
+	return spans(doc, log, snapshot)
+}
+
+type rangedMark struct {
+	start, end int
+	mark       *Mark
+}
+
+// markHeap is a heap of indices into marks, ordered by less.
+type markHeap struct {
+	indices []int
+	less    func(a, b int) bool
+}
+
+func (h *markHeap) Len() int           { return len(h.indices) }
+func (h *markHeap) Less(i, j int) bool { return h.less(h.indices[i], h.indices[j]) }
+func (h *markHeap) Swap(i, j int)      { h.indices[i], h.indices[j] = h.indices[j], h.indices[i] }
+func (h *markHeap) Push(x any)         { h.indices = append(h.indices, x.(int)) }
+func (h *markHeap) Pop() any {
+	x := h.indices[len(h.indices)-1]
+	h.indices = h.indices[:len(h.indices)-1]
+	return x
+}
+
+// spans splits content, the visible items of doc at the frontier of log,
+// into runs with the same marks. It sweeps over the items once, keeping the
+// marks covering the current one in a heap per key, so the marks of an item
+// are only worked out again where a mark starts or ends.
+func spans[T any](doc *CRDTDoc, log *OpLog[T], content []T) []Span[T] {
+	// Item i covers the points [2i, 2i+1]; anchors fall on those points.
+	points := make(map[LV]int, len(doc.Items))
+	for i, item := range doc.Items {
+		points[item.LV] = 2 * i
+	}
+	point := func(a Anchor) int {
+		switch {
+		case a.Item == -1 && a.After:
+			return -1
+		case a.Item == -1:
+			return 2 * len(doc.Items)
+		case a.After:
+			return points[a.Item] + 1
+		default:
+			return points[a.Item]
+		}
+	}
+
+	// In (lamport, agent) order, so the winner of each key is the last one
+	type rankedMark struct {
+		lv      LV
+		lamport int
+		agent   int
+	}
+	var ranked []rankedMark
+	for lv, op := range log.Ops {
+		if op.Type == OpTypeMark {
+			ranked = append(ranked, rankedMark{LV(lv), Lamport(doc, log, LV(lv)), op.Id.Agent})
+		}
+	}
+	slices.SortFunc(ranked, func(a, b rankedMark) int {
+		if c := cmp.Compare(a.lamport, b.lamport); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.agent, b.agent)
+	})
+	marks := make([]rangedMark, len(ranked))
+	for i, r := range ranked {
+		mark := log.Ops[r.lv].Mark()
+		marks[i] = rangedMark{start: point(mark.Start), end: point(mark.End), mark: mark}
+	}
+	byStart := make([]int, len(marks))
+	for i := range byStart {
+		byStart[i] = i
+	}
+	slices.SortFunc(byStart, func(a, b int) int { return cmp.Compare(marks[a].start, marks[b].start) })
+
+	active := make([]bool, len(marks))
+	ends := &markHeap{less: func(a, b int) bool { return marks[a].end < marks[b].end }}
+	byKey := map[string]*markHeap{}
+	started := 0
+
+	result := []Span[T]{}
+	var values map[string]any
+	pos := 0
+	for i, item := range doc.Items {
+		if !IsVisible(item) {
+			continue
+		}
+		changed := values == nil
+		for ; started < len(byStart) && marks[byStart[started]].start <= 2*i; started++ {
+			m := byStart[started]
+			if marks[m].end < 2*i+1 {
+				continue // Ends before an item it covers
+			}
+			key := marks[m].mark.Key
+			if byKey[key] == nil {
+				byKey[key] = &markHeap{less: func(a, b int) bool { return a > b }}
+			}
+			heap.Push(byKey[key], m)
+			heap.Push(ends, m)
+			active[m] = true
+			changed = true
+		}
+		for ends.Len() > 0 && marks[ends.indices[0]].end < 2*i+1 {
+			active[heap.Pop(ends).(int)] = false
+			changed = true
+		}
+
+		if changed {
+			values = map[string]any{}
+			for key, h := range byKey {
+				for h.Len() > 0 && !active[h.indices[0]] {
+					heap.Pop(h)
+				}
+				if h.Len() > 0 {
+					values[key] = marks[h.indices[0]].mark.Value
+				}
+			}
+			maps.DeleteFunc(values, func(_ string, v any) bool { return v == nil })
+		}
+
+		if n := len(result); n > 0 && (!changed || reflect.DeepEqual(result[n-1].Marks, values)) {
+			result[n-1].Content = append(result[n-1].Content, content[pos])
+		} else {
+			result = append(result, Span[T]{Content: []T{content[pos]}, Marks: values})
+		}
+		pos++
+	}
+	return result
+}
+
+// replayed returns the item order of the content at the frontier, applying
+// the ops pushed since the last call.
+func (doc *Document[T]) replayed() *CRDTDoc {
+	if doc.replay == nil {
+		doc.replay = newObjReplay[T](RootObj, false)
+	}
+	return doc.replay.catchUp(doc.OpLog)
+}
+
+// Mark sets key to value on positions [start, end). A nil value removes it.
+func (doc *Document[T]) Mark(start int, end int, key string, value any, expand MarkExpand) {
+	before := len(doc.OpLog.Ops)
+	LocalMark(doc.OpLog, doc.Agent, markRange(doc.replayed(), start, end, key, value, expand))
+
+	doc.Branch.Frontier = make([]LV, len(doc.OpLog.Frontier))
+	copy(doc.Branch.Frontier, doc.OpLog.Frontier)
+
+	doc.emitOps(&doc.listeners.localOps, before)
+}
+
+// Spans returns the content split into runs with the same marks.
+func (doc *Document[T]) Spans() []Span[T] {
+	return spans(doc.replayed(), doc.OpLog, doc.Branch.Snapshot)
+}
+
+// TextSpan is a run of text with the same marks.
+type TextSpan struct {
+	Text  string
+	Marks map[string]any
+}
+
+func (doc *CRDTDocument) TextSpans() []TextSpan {
+	spans := []TextSpan{}
+	for _, span := range doc.Spans() {
+		spans = append(spans, TextSpan{Text: string(span.Content), Marks: span.Marks})
+	}
+	return spans
+}
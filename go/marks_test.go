@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestMarkExpand(t *testing.T) {
+	doc := NewCRDTDocument(1)
+	doc.Ins(0, "hello world")
+	doc.Mark(0, 5, "bold", true, ExpandAfter)
+	doc.Mark(6, 11, "link", "https://example.com", ExpandNone)
+
+	// Typing at the end of the bold range extends it, the link does not grow
+	doc.Ins(5, "!")
+	doc.Ins(12, "?")
+	doc.Ins(7, "_")
+
+	expected := []TextSpan{
+		{Text: "hello!", Marks: map[string]any{"bold": true}},
+		{Text: " _", Marks: map[string]any{}},
+		{Text: "world", Marks: map[string]any{"link": "https://example.com"}},
+		{Text: "?", Marks: map[string]any{}},
+	}
+	if got := doc.TextSpans(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+
+	// Removing a mark from part of the range splits the span
+	doc.Mark(1, 3, "bold", nil, ExpandNone)
+	got := doc.TextSpans()
+	if len(got) != 6 || got[0].Text != "h" || got[1].Text != "el" || len(got[1].Marks) != 0 || got[2].Text != "lo!" {
+		t.Fatalf("Unexpected spans %v", got)
+	}
+}
+
+func TestConcurrentMarksConverge(t *testing.T) {
+	a := NewCRDTDocument(1)
+	a.Ins(0, "abcdef")
+	b := NewCRDTDocument(2)
+	b.MergeFrom(a)
+
+	a.Mark(0, 4, "color", "red", ExpandNone)
+	b.Mark(2, 6, "color", "blue", ExpandNone)
+	b.Ins(0, "xy")
+	a.MergeFrom(b)
+	b.MergeFrom(a)
+
+	// Agent 2 wins where the marks overlap
+	expected := []TextSpan{
+		{Text: "xy", Marks: map[string]any{}},
+		{Text: "ab", Marks: map[string]any{"color": "red"}},
+		{Text: "cdef", Marks: map[string]any{"color": "blue"}},
+	}
+	if got := a.TextSpans(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	if !reflect.DeepEqual(a.TextSpans(), b.TextSpans()) {
+		t.Fatalf("Documents diverged: %v / %v", a.TextSpans(), b.TextSpans())
+	}
+
+	// Marks survive the wire format
+	data, err := json.Marshal(OpsSince(a.OpLog, nil))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var ops []WireOp[rune]
+	if err := json.Unmarshal(data, &ops); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	log := NewOpLog[rune]()
+	if err := PushWireOps(log, ops); err != nil {
+		t.Fatalf("PushWireOps failed: %v", err)
+	}
+	c := NewCRDTDocumentFromOpLog(3, log)
+	if !reflect.DeepEqual(c.TextSpans(), expected) {
+		t.Fatalf("Expected %v after the wire, got %v", expected, c.TextSpans())
+	}
+}
+
+// naiveMarks returns the marks of every visible item by checking each mark
+// against each item.
+func naiveMarks(log *OpLog[rune]) []map[string]any {
+	doc, _ := replayObj(log, RootObj)
+	index := map[LV]int{}
+	for i, item := range doc.Items {
+		index[item.LV] = i
+	}
+	point := func(a Anchor) int {
+		switch {
+		case a.Item == -1 && a.After:
+			return -1
+		case a.Item == -1:
+			return 2 * len(doc.Items)
+		case a.After:
+			return 2*index[a.Item] + 1
+		default:
+			return 2 * index[a.Item]
+		}
+	}
+	covers := func(mark *Mark, i int) bool {
+		return point(mark.Start) <= 2*i && 2*i+1 <= point(mark.End)
+	}
+	result := []map[string]any{}
+	for i, item := range doc.Items {
+		if !IsVisible(item) {
+			continue
+		}
+		values := map[string]any{}
+		best := map[string][2]int{}
+		for lv, op := range log.Ops {
+			if op.Type != OpTypeMark || !covers(op.Mark(), i) {
+				continue
+			}
+			rank := [2]int{Lamport(doc, log, LV(lv)), op.Id.Agent}
+			if b, ok := best[op.Mark().Key]; !ok || b[0] < rank[0] || (b[0] == rank[0] && b[1] < rank[1]) {
+				best[op.Mark().Key] = rank
+				values[op.Mark().Key] = op.Mark().Value
+			}
+		}
+		for key, v := range values {
+			if v == nil {
+				delete(values, key)
+			}
+		}
+		result = append(result, values)
+	}
+	return result
+}
+
+func TestSpansRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	keys := []string{"bold", "italic", "color"}
+	docs := []*CRDTDocument{NewCRDTDocument(0), NewCRDTDocument(1)}
+	for i := range 600 {
+		doc := docs[r.Intn(len(docs))]
+		length := doc.Len()
+		switch {
+		case length == 0 || r.Float64() < 0.4:
+			doc.Ins(r.Intn(length+1), string(rune('a'+r.Intn(26))))
+		case r.Float64() < 0.3:
+			pos := r.Intn(length)
+			doc.Del(pos, min(length-pos, r.Intn(3)+1))
+		case r.Float64() < 0.8:
+			start := r.Intn(length)
+			var value any = i
+			if r.Intn(4) == 0 {
+				value = nil
+			}
+			doc.Mark(start, start+r.Intn(length-start)+1, keys[r.Intn(len(keys))], value, MarkExpand(r.Intn(4)))
+		default:
+			docs[0].MergeFrom(docs[1])
+			docs[1].MergeFrom(docs[0])
+		}
+
+		spans := doc.Spans()
+		if fresh := Spans(doc.OpLog); !reflect.DeepEqual(spans, fresh) {
+			t.Fatalf("Iteration %d: spans %v, from a fresh replay %v", i, spans, fresh)
+		}
+		expected := naiveMarks(doc.OpLog)
+		pos := 0
+		for _, span := range spans {
+			for range span.Content {
+				if !reflect.DeepEqual(span.Marks, expected[pos]) {
+					t.Fatalf("Iteration %d: marks %v at %d, expected %v", i, span.Marks, pos, expected[pos])
+				}
+				pos++
+			}
+		}
+		if pos != len(expected) {
+			t.Fatalf("Iteration %d: spans cover %d items, expected %d", i, pos, len(expected))
+		}
+	}
+}
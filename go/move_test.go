@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestConcurrentMovesOfSameItem(t *testing.T) {
+	a := NewDocument[string](1)
+	a.Ins(0, []string{"todo", "doing", "done"})
+	b := NewDocument[string](2)
+	b.MergeFrom(a)
+
+	a.Move(0, 2)
+	b.Move(0, 1)
+	a.MergeFrom(b)
+	b.MergeFrom(a)
+
+	// Agent 2 wins the tie, and the item is not duplicated
+	expected := []string{"doing", "todo", "done"}
+	if !slices.Equal(a.Items(), expected) || !slices.Equal(b.Items(), expected) {
+		t.Fatalf("Expected %v, got %v / %v", expected, a.Items(), b.Items())
+	}
+
+	// A later move wins over both
+	a.Move(1, 0)
+	b.MergeFrom(a)
+	expected = []string{"todo", "doing", "done"}
+	if !slices.Equal(b.Items(), expected) {
+		t.Fatalf("Expected %v, got %v", expected, b.Items())
+	}
+}
+
+func TestMoveConcurrentWithDelete(t *testing.T) {
+	a := NewDocument[string](1)
+	a.Ins(0, []string{"x", "y", "z"})
+	b := NewDocument[string](2)
+	b.MergeFrom(a)
+
+	a.Move(0, 2)
+	b.Del(0, 1)
+	a.MergeFrom(b)
+	b.MergeFrom(a)
+
+	expected := []string{"y", "z"}
+	if !slices.Equal(a.Items(), expected) || !slices.Equal(b.Items(), expected) {
+		t.Fatalf("Expected %v, got %v / %v", expected, a.Items(), b.Items())
+	}
+
+	// Inserting next to a moved item lands next to where it is now
+	a.Ins(0, []string{"w"})
+	a.Move(2, 0)
+	b.MergeFrom(a)
+	expected = []string{"z", "w", "y"}
+	if !slices.Equal(b.Items(), expected) {
+		t.Fatalf("Expected %v, got %v", expected, b.Items())
+	}
+}
+
+func TestMoveOutOfRange(t *testing.T) {
+	doc := NewDocument[string](1)
+	doc.Ins(0, []string{"a", "b"})
+	for _, move := range [][2]int{{2, 0}, {-1, 0}, {0, 2}, {1, -1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Move(%d, %d) did not panic", move[0], move[1])
+				}
+			}()
+			doc.Move(move[0], move[1])
+		}()
+	}
+	if len(doc.OpLog.Ops) != 2 || !slices.Equal(doc.Items(), []string{"a", "b"}) {
+		t.Fatalf("Invalid moves changed the document: %d ops, %v", len(doc.OpLog.Ops), doc.Items())
+	}
+}
+
+func TestMoveFuzzer(t *testing.T) {
+	for seed := range 30 {
+		r := rand.New(rand.NewSource(int64(seed)))
+		docs := []*Document[int]{NewDocument[int](0), NewDocument[int](1), NewDocument[int](2)}
+		var patched [3][]int
+		for i, doc := range docs {
+			doc.OnPatch(func(p Patch[int]) {
+				patched[i] = slices.Insert(slices.Delete(patched[i], p.Pos, p.Pos+p.DelLen), p.Pos, p.Content...)
+			})
+		}
+
+		next := 0
+		for i := range 100 {
+			for range 2 {
+				doc := docs[r.Intn(len(docs))]
+				switch {
+				case doc.Len() == 0 || r.Float64() < 0.4:
+					doc.Ins(r.Intn(doc.Len()+1), []int{next})
+					next++
+				case r.Float64() < 0.3:
+					doc.Del(r.Intn(doc.Len()), 1)
+				default:
+					doc.Move(r.Intn(doc.Len()), r.Intn(doc.Len()))
+				}
+			}
+
+			a, b := docs[r.Intn(len(docs))], docs[r.Intn(len(docs))]
+			if a == b {
+				continue
+			}
+			a.MergeFrom(b)
+			b.MergeFrom(a)
+			if !slices.Equal(a.Items(), b.Items()) {
+				t.Fatalf("Seed %d iteration %d: documents diverged\n%v\n%v", seed, i, a.Items(), b.Items())
+			}
+			if !slices.Equal(a.Items(), Checkout(a.OpLog)) {
+				t.Fatalf("Seed %d iteration %d: merged document does not match a full checkout", seed, i)
+			}
+			items := slices.Sorted(slices.Values(a.Items()))
+			if len(slices.Compact(items)) != a.Len() {
+				t.Fatalf("Seed %d iteration %d: duplicated items %v", seed, i, a.Items())
+			}
+			for j, doc := range docs {
+				if !slices.Equal(patched[j], doc.Items()) {
+					t.Fatalf("Seed %d iteration %d: patches do not match document %d", seed, i, j)
+				}
+			}
+		}
+	}
+}
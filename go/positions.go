@@ -0,0 +1,221 @@
+package main
+
+import (
+	"egwalker/bxtree"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ==========================================
+// Position Units
+// ==========================================
+
+var ErrInvalidPos = errors.New("invalid position")
+
+// PosUnit is the unit positions and lengths in the text are counted in.
+// CRDTDocument positions are runes; editors in the browser count UTF-16 code
+// units and some LSP clients count UTF-8 bytes.
+type PosUnit int
+
+const (
+	UnitRune PosUnit = iota
+	UnitUTF8
+	UnitUTF16
+
+	numPosUnits = 3
+)
+
+func (unit PosUnit) String() string {
+	switch unit {
+	case UnitRune:
+		return "rune"
+	case UnitUTF8:
+		return "utf8"
+	case UnitUTF16:
+		return "utf16"
+	}
+	return fmt.Sprintf("PosUnit(%d)", int(unit))
+}
+
+// Invalid runes are counted as the replacement character they are encoded as.
+func utf8Len(r rune) int {
+	if n := utf8.RuneLen(r); n > 0 {
+		return n
+	}
+	return utf8.RuneLen(utf8.RuneError)
+}
+
+func utf16Len(r rune) int {
+	if n := utf16.RuneLen(r); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// runeWidths is what the text index keeps of a rune, packed in a byte: its
+// UTF-8 length in bits 0-2, its UTF-16 length in bits 3-4, and whether it
+// is a newline in bit 5.
+type runeWidths uint8
+
+func widthsOf(r rune) runeWidths {
+	w := runeWidths(utf8Len(r) | utf16Len(r)<<3)
+	if r == '\n' {
+		w |= 1 << 5
+	}
+	return w
+}
+
+func widthsOfText(text []rune) []runeWidths {
+	widths := make([]runeWidths, len(text))
+	for i, r := range text {
+		widths[i] = widthsOf(r)
+	}
+	return widths
+}
+
+// Measures of the text index
+var (
+	measureUTF8     = bxtree.NewMeasure(func(w runeWidths) int { return int(w & 7) })
+	measureUTF16    = bxtree.NewMeasure(func(w runeWidths) int { return int(w >> 3 & 3) })
+	measureNewlines = bxtree.NewMeasure(func(w runeWidths) int { return int(w >> 5 & 1) })
+)
+
+func measureOf(unit PosUnit) *bxtree.Measure[runeWidths] {
+	if unit == UnitUTF8 {
+		return measureUTF8
+	}
+	return measureUTF16
+}
+
+// newTextIndex builds the index of text that positions in other units and
+// lines are looked up in. The text itself stays in the branch snapshot,
+// which the merge code splices as a plain slice; the index only keeps one
+// byte per rune, a quarter of the size of the text.
+func newTextIndex(text []rune) *bxtree.BxTree[runeWidths] {
+	// Leaves are left with room for typing before they split
+	tree, err := bxtree.FromSlice(widthsOfText(text), 0.75, bxtree.WithMeasures(measureUTF8, measureUTF16, measureNewlines))
+	if err != nil {
+		panic("Text index load failed")
+	}
+	return tree
+}
+
+func newCRDTDocument(doc *Document[rune]) *CRDTDocument {
+	d := &CRDTDocument{
+		Document: doc,
+		widths:   newTextIndex(doc.Branch.Snapshot),
+	}
+	// Registered first, so the index is up to date for every other listener
+	doc.OnPatch(d.updateTextIndex)
+	return d
+}
+
+func (doc *CRDTDocument) updateTextIndex(p Patch[rune]) {
+	var patches [numPosUnits]TextPatch
+	for unit := range PosUnit(numPosUnits) {
+		pos := doc.fromRunes(unit, p.Pos)
+		patches[unit] = TextPatch{
+			Pos:    pos,
+			DelLen: doc.fromRunes(unit, p.Pos+p.DelLen) - pos,
+			Text:   string(p.Content),
+		}
+	}
+
+	if err := doc.widths.DeleteRange(p.Pos, p.DelLen); err != nil {
+		panic("Text index delete failed")
+	}
+	if err := doc.widths.InsertRange(p.Pos, widthsOfText(p.Content)); err != nil {
+		panic("Text index insert failed")
+	}
+
+	for unit := range PosUnit(numPosUnits) {
+		doc.unitListeners[unit].emit(patches[unit])
+	}
+}
+
+// fromRunes converts a valid rune position.
+func (doc *CRDTDocument) fromRunes(unit PosUnit, pos int) int {
+	if unit == UnitRune {
+		return pos
+	}
+	n, err := doc.widths.PrefixSum(measureOf(unit), pos)
+	if err != nil {
+		panic("Text index out of sync")
+	}
+	return n
+}
+
+// toRunes converts a position in unit to runes. It fails if pos is out of
+// range or in the middle of a character.
+func (doc *CRDTDocument) toRunes(unit PosUnit, pos int) (int, error) {
+	if unit == UnitRune {
+		if pos < 0 || pos > doc.widths.Size() {
+			return 0, fmt.Errorf("%w: %d runes in a text of %d", ErrInvalidPos, pos, doc.widths.Size())
+		}
+		return pos, nil
+	}
+	n, offset, err := doc.widths.SeekBy(measureOf(unit), pos)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %d %s units in a text of %d", ErrInvalidPos, pos, unit, doc.Length(unit))
+	}
+	if offset != 0 {
+		return 0, fmt.Errorf("%w: %d %s units is inside a character", ErrInvalidPos, pos, unit)
+	}
+	return n, nil
+}
+
+// Length returns the length of the text in unit.
+func (doc *CRDTDocument) Length(unit PosUnit) int {
+	if unit == UnitRune {
+		return doc.widths.Size()
+	}
+	return doc.widths.Sum(measureOf(unit))
+}
+
+// ConvertPos converts a position in the text from one unit to another.
+func (doc *CRDTDocument) ConvertPos(pos int, from PosUnit, to PosUnit) (int, error) {
+	n, err := doc.toRunes(from, pos)
+	if err != nil {
+		return 0, err
+	}
+	return doc.fromRunes(to, n), nil
+}
+
+// InsAt inserts text at pos, counted in unit.
+func (doc *CRDTDocument) InsAt(unit PosUnit, pos int, text string) error {
+	n, err := doc.toRunes(unit, pos)
+	if err != nil {
+		return err
+	}
+	doc.Ins(n, text)
+	return nil
+}
+
+// DelAt deletes delLen units of text at pos.
+func (doc *CRDTDocument) DelAt(unit PosUnit, pos int, delLen int) error {
+	start, err := doc.toRunes(unit, pos)
+	if err != nil {
+		return err
+	}
+	end, err := doc.toRunes(unit, pos+delLen)
+	if err != nil {
+		return err
+	}
+	if end < start {
+		return fmt.Errorf("%w: negative length %d", ErrInvalidPos, delLen)
+	}
+	doc.Del(start, end-start)
+	return nil
+}
+
+// OnPatchIn is OnPatch with positions and lengths counted in unit.
+func (doc *CRDTDocument) OnPatchIn(unit PosUnit, fn func(patch TextPatch)) *Subscription {
+	return doc.unitListeners[unit].add(fn)
+}
+
+func (doc *CRDTDocument) Reset() {
+	doc.Document.Reset()
+	doc.widths = newTextIndex(nil)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"slices"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestConvertPos(t *testing.T) {
+	doc := NewCRDTDocument(1)
+	doc.Ins(0, "aé😀b")
+
+	// rune, UTF-8 and UTF-16 offsets of each boundary
+	boundaries := [][3]int{{0, 0, 0}, {1, 1, 1}, {2, 3, 2}, {3, 7, 4}, {4, 8, 5}}
+	for _, b := range boundaries {
+		for from := range PosUnit(numPosUnits) {
+			for to := range PosUnit(numPosUnits) {
+				got, err := doc.ConvertPos(b[from], from, to)
+				if err != nil || got != b[to] {
+					t.Fatalf("ConvertPos(%d, %s, %s) = %d, %v, expected %d", b[from], from, to, got, err, b[to])
+				}
+			}
+		}
+	}
+	if doc.Length(UnitUTF8) != 8 || doc.Length(UnitUTF16) != 5 {
+		t.Fatalf("Unexpected lengths %d / %d", doc.Length(UnitUTF8), doc.Length(UnitUTF16))
+	}
+
+	// Between the two halves of the surrogate pair, and past the end
+	if _, err := doc.ConvertPos(3, UnitUTF16, UnitRune); !errors.Is(err, ErrInvalidPos) {
+		t.Fatalf("Expected ErrInvalidPos, got %v", err)
+	}
+	if _, err := doc.ConvertPos(9, UnitUTF8, UnitRune); !errors.Is(err, ErrInvalidPos) {
+		t.Fatalf("Expected ErrInvalidPos, got %v", err)
+	}
+
+	if err := doc.InsAt(UnitUTF16, 4, "!"); err != nil {
+		t.Fatalf("InsAt failed: %v", err)
+	}
+	if err := doc.DelAt(UnitUTF8, 1, 2); err != nil {
+		t.Fatalf("DelAt failed: %v", err)
+	}
+	if doc.GetString() != "a😀!b" {
+		t.Fatalf("Unexpected text %q", doc.GetString())
+	}
+}
+
+func TestPatchesInUTF16(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	alphabet := []rune("ab é😀\n")
+	docs := []*CRDTDocument{NewCRDTDocument(0), NewCRDTDocument(1), NewCRDTDocument(2)}
+
+	// What a browser editor holding UTF-16 text would see
+	var editors [3][]uint16
+	for i, doc := range docs {
+		doc.OnPatchIn(UnitUTF16, func(p TextPatch) {
+			editors[i] = slices.Insert(slices.Delete(editors[i], p.Pos, p.Pos+p.DelLen), p.Pos, utf16.Encode([]rune(p.Text))...)
+		})
+	}
+
+	for i := range 300 {
+		doc := docs[r.Intn(len(docs))]
+		if doc.Len() == 0 || r.Float64() < 0.6 {
+			text := string(alphabet[r.Intn(len(alphabet))]) + string(alphabet[r.Intn(len(alphabet))])
+			doc.Ins(r.Intn(doc.Len()+1), text)
+		} else {
+			pos := r.Intn(doc.Len())
+			doc.Del(pos, min(r.Intn(3)+1, doc.Len()-pos))
+		}
+		a, b := docs[r.Intn(len(docs))], docs[r.Intn(len(docs))]
+		a.MergeFrom(b)
+
+		for j, doc := range docs {
+			if string(utf16.Decode(editors[j])) != doc.GetString() {
+				t.Fatalf("Iteration %d: editor %d has %q, document has %q", i, j, string(utf16.Decode(editors[j])), doc.GetString())
+			}
+			if doc.Length(UnitUTF16) != len(editors[j]) {
+				t.Fatalf("Iteration %d: UTF-16 length %d, expected %d", i, doc.Length(UnitUTF16), len(editors[j]))
+			}
+		}
+	}
+}
+
+func TestRuneWidths(t *testing.T) {
+	for _, r := range []rune{'a', '\n', 'é', '€', '😀', -1, 0x10FFFF} {
+		index := newTextIndex([]rune{r})
+		if got := index.Sum(measureUTF8); got != utf8Len(r) {
+			t.Errorf("UTF-8 length of %U is %d, expected %d", r, got, utf8Len(r))
+		}
+		if got := index.Sum(measureUTF16); got != utf16Len(r) {
+			t.Errorf("UTF-16 length of %U is %d, expected %d", r, got, utf16Len(r))
+		}
+		if got, newline := index.Sum(measureNewlines), r == '\n'; (got == 1) != newline {
+			t.Errorf("Newline count of %U is %d", r, got)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// ==========================================
+// Registers and Maps
+// ==========================================
+
+var ErrInvalidValue = errors.New("value cannot be encoded as JSON")
+
+// Set ops turn the oplog into a map of last-writer-wins registers, stored
+// next to the sequence and synced with it. A set overrides every set of the
+// same key it has seen (through its parents). Of the sets nobody has seen
+// yet, the one with the highest (lamport, agent) wins.
+//
+// Values travel as JSON, so they should be JSON values; Document.Set makes
+// sure of it.
+
+func LocalSet[T any](log *OpLog[T], agent int, key string, value any) {
+	log.PushLocalOp(agent, Op[T]{
+		Type: OpTypeSet,
+		Ext:  &OpExt{Key: key, Value: value},
+	})
+}
+
+// HappenedBefore reports whether op a is in the history of op b.
+func HappenedBefore[T any](log *OpLog[T], a LV, b LV) bool {
+	if a >= b {
+		return false
+	}
+	visited := map[LV]bool{}
+	stack := slices.Clone(log.Ops[b].Parents)
+	for len(stack) > 0 {
+		lv := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if lv == a {
+			return true
+		}
+		if lv < a || visited[lv] {
+			continue
+		}
+		visited[lv] = true
+		stack = append(stack, log.Ops[lv].Parents...)
+	}
+	return false
+}
+
+// registers are the heads of every key of every container, kept at the
+// frontier of the oplog: set ops pushed since the last use are added on top
+// instead of scanning the whole oplog again.
+type registers[T any] struct {
+	heads    map[ObjId]map[string][]LV
+	lamports []int
+	next     int // ops before next have been added
+}
+
+func newRegisters[T any]() *registers[T] {
+	return &registers[T]{heads: map[ObjId]map[string][]LV{}}
+}
+
+// catchUp adds the set ops pushed since the last call and returns the heads
+// of every container.
+func (r *registers[T]) catchUp(log *OpLog[T]) map[ObjId]map[string][]LV {
+	for ; r.next < len(log.Ops); r.next++ {
+		if log.Ops[r.next].Type == OpTypeSet {
+			r.add(log, LV(r.next))
+		}
+	}
+	return r.heads
+}
+
+// add makes the set at lv a head of its key, in place of the heads it has
+// seen. Heads stay sorted by (lamport, agent).
+func (r *registers[T]) add(log *OpLog[T], lv LV) {
+	op := log.Ops[lv]
+	keys := r.heads[op.Obj()]
+	if keys == nil {
+		keys = map[string][]LV{}
+		r.heads[op.Obj()] = keys
+	}
+	heads := slices.DeleteFunc(keys[op.Key()], func(h LV) bool {
+		return HappenedBefore(log, h, lv)
+	})
+	at, _ := slices.BinarySearchFunc(heads, lv, func(a, b LV) int {
+		if la, lb := lamport(&r.lamports, log, a), lamport(&r.lamports, log, b); la != lb {
+			return la - lb
+		}
+		return log.Ops[a].Id.Agent - log.Ops[b].Id.Agent
+	})
+	keys[op.Key()] = slices.Insert(heads, at, lv)
+}
+
+// registerHeads returns, for every key of the container obj, the sets of
+// that key that no other set has seen, sorted by (lamport, agent).
+func registerHeads[T any](log *OpLog[T], obj ObjId) map[string][]LV {
+	return newRegisters[T]().catchUp(log)[obj]
+}
+
+// readMap returns the winning value of every key of heads that is set.
+func readMap[T any](log *OpLog[T], heads map[string][]LV) map[string]any {
+	values := map[string]any{}
+	for key, lvs := range heads {
+		if v := log.Ops[lvs[len(lvs)-1]].Value(); v != nil {
+			values[key] = v
+		}
+	}
+	return values
+}
+
+// registerValues returns the values of the sets in lvs.
+func registerValues[T any](log *OpLog[T], lvs []LV) []any {
+	values := []any{}
+	for _, lv := range lvs {
+		values = append(values, log.Ops[lv].Value())
+	}
+	return values
+}
+
+// ReadMap returns the winning value of every key that is set.
+func ReadMap[T any](log *OpLog[T]) map[string]any {
+	return readMap(log, registerHeads(log, RootObj))
+}
+
+// RegisterValues returns the values of concurrent sets of key that have not
+// been overridden, the winner last. It has more than one entry when there
+// is a conflict the application may want to show.
+func RegisterValues[T any](log *OpLog[T], key string) []any {
+	return registerValues(log, registerHeads(log, RootObj)[key])
+}
+
+// jsonValue returns value as it comes out of the wire format: numbers become
+// float64, structs become maps, and so on.
+func jsonValue(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	return v, nil
+}
+
+// Set writes value under key. A nil value deletes the key. The value is
+// stored the way every replica will read it after a sync, e.g. 3 becomes
+// float64(3); values JSON cannot encode are rejected with ErrInvalidValue.
+func (doc *Document[T]) Set(key string, value any) error {
+	value, err := jsonValue(value)
+	if err != nil {
+		return err
+	}
+	before := len(doc.OpLog.Ops)
+	LocalSet(doc.OpLog, doc.Agent, key, value)
+
+	doc.Branch.Frontier = make([]LV, len(doc.OpLog.Frontier))
+	copy(doc.Branch.Frontier, doc.OpLog.Frontier)
+
+	doc.emitOps(&doc.listeners.localOps, before)
+	return nil
+}
+
+// rootHeads returns the heads of the keys of the document, adding the set
+// ops pushed since the last call.
+func (doc *Document[T]) rootHeads() map[string][]LV {
+	if doc.registers == nil {
+		doc.registers = newRegisters[T]()
+	}
+	return doc.registers.catchUp(doc.OpLog)[RootObj]
+}
+
+func (doc *Document[T]) Get(key string) (any, bool) {
+	lvs := doc.rootHeads()[key]
+	if len(lvs) == 0 {
+		return nil, false
+	}
+	value := doc.OpLog.Ops[lvs[len(lvs)-1]].Value()
+	return value, value != nil
+}
+
+// Keys returns the keys that are set, sorted.
+func (doc *Document[T]) Keys() []string {
+	return slices.Sorted(maps.Keys(readMap(doc.OpLog, doc.rootHeads())))
+}
+
+// Conflicts returns the concurrent values of key, the winner last.
+func (doc *Document[T]) Conflicts(key string) []any {
+	return registerValues(doc.OpLog, doc.rootHeads()[key])
+}
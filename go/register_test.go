@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestRegisterLastWriterWins(t *testing.T) {
+	a := NewCRDTDocument(1)
+	a.Ins(0, "notes")
+	a.Set("title", "Draft")
+	b := NewCRDTDocument(2)
+	b.MergeFrom(a)
+
+	// b has seen "Draft", so its set wins whatever the agents
+	b.Set("title", "Final")
+	a.Set("tags", []any{"go", "crdt"})
+	a.MergeFrom(b)
+	if v, _ := a.Get("title"); v != "Final" {
+		t.Fatalf("Expected the later set to win, got %v", v)
+	}
+	if len(a.Conflicts("title")) != 1 {
+		t.Fatalf("Expected no conflict, got %v", a.Conflicts("title"))
+	}
+
+	// Concurrent sets: both are kept, agent 2 wins the tie
+	a.Set("owner", "alice")
+	b.Set("owner", "bob")
+	a.MergeFrom(b)
+	b.MergeFrom(a)
+	for _, doc := range []*CRDTDocument{a, b} {
+		if v, _ := doc.Get("owner"); v != "bob" {
+			t.Fatalf("Expected bob to win, got %v", v)
+		}
+		if !reflect.DeepEqual(doc.Conflicts("owner"), []any{"alice", "bob"}) {
+			t.Fatalf("Unexpected conflicts %v", doc.Conflicts("owner"))
+		}
+	}
+
+	// Deleting resolves the conflict
+	a.Set("owner", nil)
+	b.MergeFrom(a)
+	if _, ok := b.Get("owner"); ok {
+		t.Fatalf("Expected owner to be deleted")
+	}
+	if !slices.Equal(b.Keys(), []string{"tags", "title"}) {
+		t.Fatalf("Unexpected keys %v", b.Keys())
+	}
+	if b.GetString() != "notes" {
+		t.Fatalf("Text changed to %q", b.GetString())
+	}
+}
+
+func TestRegisterWireFormat(t *testing.T) {
+	doc := NewCRDTDocument(1)
+	doc.Ins(0, "hi")
+	doc.Set("permissions", map[string]any{"bob": "read"})
+	doc.Set("archived", false)
+
+	data, err := json.Marshal(OpsSince(doc.OpLog, nil))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var ops []WireOp[rune]
+	if err := json.Unmarshal(data, &ops); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	log := NewOpLog[rune]()
+	if err := PushWireOps(log, ops); err != nil {
+		t.Fatalf("PushWireOps failed: %v", err)
+	}
+
+	expected := map[string]any{"permissions": map[string]any{"bob": "read"}, "archived": false}
+	if got := ReadMap(log); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	if string(Checkout(log)) != "hi" {
+		t.Fatalf("Unexpected text %q", string(Checkout(log)))
+	}
+}
+
+func TestRegisterValueSurvivesSync(t *testing.T) {
+	type owner struct {
+		Name string `json:"name"`
+	}
+	a := NewCRDTDocument(1)
+	a.Set("n", 3)
+	a.Set("owner", owner{Name: "alice"})
+	a.Set("tags", []string{"go"})
+	if err := a.Set("bad", func() {}); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("Expected ErrInvalidValue, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSyncMessage(&buf, SyncMessage{Type: SyncMessageOps, Ops: OpsSince(a.OpLog, nil)}); err != nil {
+		t.Fatalf("WriteSyncMessage failed: %v", err)
+	}
+	msg, err := ReadSyncMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadSyncMessage failed: %v", err)
+	}
+	b := NewCRDTDocument(2)
+	if err := b.applyWireOps(msg.Ops); err != nil {
+		t.Fatalf("applyWireOps failed: %v", err)
+	}
+
+	if !slices.Equal(a.Keys(), []string{"n", "owner", "tags"}) {
+		t.Fatalf("Unexpected keys %v", a.Keys())
+	}
+	for _, key := range a.Keys() {
+		before, _ := a.Get(key)
+		after, _ := b.Get(key)
+		if !reflect.DeepEqual(before, after) {
+			t.Fatalf("%s is %#v before the sync and %#v after", key, before, after)
+		}
+	}
+	if v, _ := a.Get("n"); v != float64(3) {
+		t.Fatalf("Expected float64(3), got %#v", v)
+	}
+}
+
+// naiveConflicts finds the sets of key that no other set of key has seen by
+// comparing every pair, the reference for the heads kept by Document.
+func naiveConflicts[T any](log *OpLog[T], key string) []any {
+	var lamports []int
+	heads := []LV{}
+	for i, op := range log.Ops {
+		if op.Type != OpTypeSet || op.Key() != key {
+			continue
+		}
+		seen := false
+		for j := i + 1; j < len(log.Ops); j++ {
+			if log.Ops[j].Type == OpTypeSet && log.Ops[j].Key() == key && HappenedBefore(log, LV(i), LV(j)) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			heads = append(heads, LV(i))
+		}
+	}
+	slices.SortFunc(heads, func(a, b LV) int {
+		if la, lb := lamport(&lamports, log, a), lamport(&lamports, log, b); la != lb {
+			return la - lb
+		}
+		return log.Ops[a].Id.Agent - log.Ops[b].Id.Agent
+	})
+	values := []any{}
+	for _, lv := range heads {
+		values = append(values, log.Ops[lv].Value())
+	}
+	return values
+}
+
+func TestRegisterRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	keys := []string{"a", "b", "c"}
+	docs := []*Document[rune]{NewDocument[rune](1), NewDocument[rune](2), NewDocument[rune](3)}
+	for i := 0; i < 400; i++ {
+		doc := docs[r.Intn(len(docs))]
+		switch r.Intn(4) {
+		case 0:
+			doc.MergeFrom(docs[r.Intn(len(docs))])
+		case 1:
+			doc.Set(keys[r.Intn(len(keys))], nil)
+		default:
+			doc.Set(keys[r.Intn(len(keys))], fmt.Sprint(i))
+		}
+		if r.Intn(3) > 0 {
+			continue // Let ops pile up between reads
+		}
+		for _, key := range keys {
+			expected := naiveConflicts(doc.OpLog, key)
+			if got := doc.Conflicts(key); !reflect.DeepEqual(got, expected) {
+				t.Fatalf("Iteration %d: conflicts of %s are %v, expected %v", i, key, got, expected)
+			}
+			value, ok := doc.Get(key)
+			if len(expected) > 0 && expected[len(expected)-1] != nil {
+				if !ok || value != expected[len(expected)-1] {
+					t.Fatalf("Iteration %d: %s is %v, expected %v", i, key, value, expected[len(expected)-1])
+				}
+			} else if ok {
+				t.Fatalf("Iteration %d: %s is set to %v", i, key, value)
+			}
+		}
+	}
+}
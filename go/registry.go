@@ -0,0 +1,533 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ==========================================
+// Document Stores
+// ==========================================
+
+var (
+	ErrDocumentExists   = errors.New("document already exists")
+	ErrDocumentNotFound = errors.New("document not found")
+	ErrDocumentInUse    = errors.New("document is in use")
+)
+
+// DocumentStore persists oplogs by document id. Load returns an error
+// wrapping ErrDocumentNotFound for unknown ids.
+type DocumentStore interface {
+	Load(id string) (*OpLog[rune], error)
+	Save(id string, log *OpLog[rune]) error
+	Delete(id string) error
+	Exists(id string) (bool, error)
+	List() ([]string, error)
+}
+
+func encodeOpLog(log *OpLog[rune]) ([]byte, error) {
+	return json.Marshal(OpsSince(log, nil))
+}
+
+func decodeOpLog(data []byte) (*OpLog[rune], error) {
+	var ops []WireOp[rune]
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, err
+	}
+	log := NewOpLog[rune]()
+	if err := PushWireOps(log, ops); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// MemoryStore keeps encoded oplogs in memory. Loaded oplogs never share
+// memory with saved ones.
+type MemoryStore struct {
+	mu   sync.Mutex
+	logs map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{logs: make(map[string][]byte)}
+}
+
+func (store *MemoryStore) Load(id string) (*OpLog[rune], error) {
+	store.mu.Lock()
+	data, ok := store.logs[id]
+	store.mu.Unlock()
+	if !ok {
+		return nil, ErrDocumentNotFound
+	}
+	return decodeOpLog(data)
+}
+
+func (store *MemoryStore) Save(id string, log *OpLog[rune]) error {
+	data, err := encodeOpLog(log)
+	if err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.logs[id] = data
+	return nil
+}
+
+func (store *MemoryStore) Delete(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.logs, id)
+	return nil
+}
+
+func (store *MemoryStore) Exists(id string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	_, ok := store.logs[id]
+	return ok, nil
+}
+
+func (store *MemoryStore) List() ([]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return slices.Sorted(maps.Keys(store.logs)), nil
+}
+
+// DirStore keeps one JSON file per document in a directory.
+type DirStore struct {
+	dir string
+}
+
+const dirStoreExt = ".json"
+
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+func (store *DirStore) path(id string) string {
+	return filepath.Join(store.dir, url.PathEscape(id)+dirStoreExt)
+}
+
+func (store *DirStore) Load(id string) (*OpLog[rune], error) {
+	data, err := os.ReadFile(store.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeOpLog(data)
+}
+
+// Save writes to a temporary file first, so a crash never leaves a
+// truncated document behind.
+func (store *DirStore) Save(id string, log *OpLog[rune]) error {
+	data, err := encodeOpLog(log)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(store.dir, "save-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), store.path(id))
+}
+
+func (store *DirStore) Delete(id string) error {
+	err := os.Remove(store.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (store *DirStore) Exists(id string) (bool, error) {
+	_, err := os.Stat(store.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (store *DirStore) List() ([]string, error) {
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), dirStoreExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if id, err := url.PathUnescape(name); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// ==========================================
+// Document Registry
+// ==========================================
+
+// Registry hosts documents by id. Documents are loaded from the store on
+// first use and kept in memory while they are in use; at most capacity idle
+// documents stay resident, the least recently used ones are saved and
+// evicted first.
+//
+// The store is never called with the registry lock held, so a slow save or
+// load only holds up the document it is for.
+//
+// Every successful Create or Open must be paired with a Release once the
+// caller is done with the document. Create and Open may evict other
+// documents; a document that fails to save stays resident, and the error
+// is reported by the next Flush rather than to the caller that happened to
+// trigger the eviction.
+type Registry struct {
+	store    DocumentStore
+	agent    int
+	capacity int
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List // of *registryEntry, most recently used first
+	evictErr error      // Failed evictions since the last Flush
+}
+
+type registryEntry struct {
+	id  string
+	doc *ConcurrentDocument
+
+	// Guarded by the registry lock
+	refs     int
+	opens    int  // times it was created or opened, to spot reopens during an eviction
+	saved    int  // number of ops at the last save, -1 if never saved
+	evicting bool // picked for eviction, being saved
+	deleted  bool
+
+	saveMu sync.Mutex // held while saving, so that saves reach the store in order
+
+	ready chan struct{} // closed once doc is loaded
+	err   error
+}
+
+// eviction is an entry picked for eviction while it had been opened opens
+// times. It is only dropped if it has not been opened again since.
+type eviction struct {
+	entry *registryEntry
+	opens int
+}
+
+func NewRegistry(store DocumentStore, agent int, capacity int) *Registry {
+	return &Registry{
+		store:    store,
+		agent:    agent,
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Create makes a new empty document.
+func (registry *Registry) Create(id string) (*ConcurrentDocument, error) {
+	registry.mu.Lock()
+	_, resident := registry.entries[id]
+	registry.mu.Unlock()
+	if resident {
+		return nil, ErrDocumentExists
+	}
+	if exists, err := registry.store.Exists(id); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, ErrDocumentExists
+	}
+
+	registry.mu.Lock()
+	if _, ok := registry.entries[id]; ok {
+		registry.mu.Unlock()
+		return nil, ErrDocumentExists
+	}
+	entry := &registryEntry{
+		id:    id,
+		doc:   NewConcurrentDocument(registry.agent),
+		refs:  1,
+		opens: 1,
+		saved: -1,
+		ready: make(chan struct{}),
+	}
+	close(entry.ready)
+	registry.entries[id] = registry.lru.PushFront(entry)
+	evictions := registry.evictLocked()
+	registry.mu.Unlock()
+
+	registry.evict(evictions)
+	return entry.doc, nil
+}
+
+// Open returns the document with this id, loading it if it is not resident.
+// Concurrent opens of the same id share a single load.
+func (registry *Registry) Open(id string) (*ConcurrentDocument, error) {
+	registry.mu.Lock()
+	if el, ok := registry.entries[id]; ok {
+		entry := el.Value.(*registryEntry)
+		entry.refs++
+		entry.opens++
+		entry.evicting = false
+		registry.lru.MoveToFront(el)
+		registry.mu.Unlock()
+
+		<-entry.ready
+		return entry.doc, entry.err
+	}
+
+	entry := &registryEntry{
+		id:    id,
+		refs:  1,
+		opens: 1,
+		ready: make(chan struct{}),
+	}
+	el := registry.lru.PushFront(entry)
+	registry.entries[id] = el
+	registry.mu.Unlock()
+
+	// Load without holding the registry lock
+	log, err := registry.store.Load(id)
+
+	registry.mu.Lock()
+	if err != nil {
+		entry.err = err
+		registry.lru.Remove(el)
+		delete(registry.entries, id)
+		close(entry.ready)
+		registry.mu.Unlock()
+		return nil, err
+	}
+	entry.doc = &ConcurrentDocument{doc: NewCRDTDocumentFromOpLog(registry.agent, log)}
+	entry.saved = len(log.Ops)
+	close(entry.ready)
+	evictions := registry.evictLocked()
+	registry.mu.Unlock()
+
+	registry.evict(evictions)
+	return entry.doc, nil
+}
+
+// Release marks one use of the document as finished.
+func (registry *Registry) Release(id string) {
+	registry.mu.Lock()
+	el, ok := registry.entries[id]
+	if !ok {
+		registry.mu.Unlock()
+		return
+	}
+	entry := el.Value.(*registryEntry)
+	if entry.refs > 0 {
+		entry.refs--
+	}
+	evictions := registry.evictLocked()
+	registry.mu.Unlock()
+
+	registry.evict(evictions)
+}
+
+// Close saves the document and drops it from memory. It fails with
+// ErrDocumentInUse if it has not been released.
+func (registry *Registry) Close(id string) error {
+	registry.mu.Lock()
+	el, ok := registry.entries[id]
+	if !ok {
+		registry.mu.Unlock()
+		return nil
+	}
+	entry := el.Value.(*registryEntry)
+	if entry.refs > 0 {
+		registry.mu.Unlock()
+		return ErrDocumentInUse
+	}
+	entry.evicting = true
+	ev := eviction{entry: entry, opens: entry.opens}
+	registry.mu.Unlock()
+
+	return registry.drop(ev)
+}
+
+// Delete removes the document from memory and from the store.
+func (registry *Registry) Delete(id string) error {
+	registry.mu.Lock()
+	var entry *registryEntry
+	if el, ok := registry.entries[id]; ok {
+		entry = el.Value.(*registryEntry)
+		if entry.refs > 0 {
+			registry.mu.Unlock()
+			return ErrDocumentInUse
+		}
+		entry.deleted = true
+		registry.lru.Remove(el)
+		delete(registry.entries, id)
+	}
+	registry.mu.Unlock()
+
+	if entry != nil {
+		// Wait for a save in progress, which would bring the document back
+		entry.saveMu.Lock()
+		defer entry.saveMu.Unlock()
+	}
+	return registry.store.Delete(id)
+}
+
+// List returns the ids of every document, resident or stored.
+func (registry *Registry) List() ([]string, error) {
+	ids, err := registry.store.List()
+	if err != nil {
+		return nil, err
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for id, el := range registry.entries {
+		if el.Value.(*registryEntry).doc != nil && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Flush saves every resident document that changed since it was last saved.
+// It also reports the evictions that failed since the last Flush.
+func (registry *Registry) Flush() error {
+	registry.mu.Lock()
+	errs := []error{registry.evictErr}
+	registry.evictErr = nil
+	var entries []*registryEntry
+	for el := registry.lru.Front(); el != nil; el = el.Next() {
+		if entry := el.Value.(*registryEntry); entry.doc != nil {
+			entries = append(entries, entry)
+		}
+	}
+	registry.mu.Unlock()
+
+	for _, entry := range entries {
+		errs = append(errs, registry.save(entry))
+	}
+	return errors.Join(errs...)
+}
+
+// Resident returns the number of documents held in memory.
+func (registry *Registry) Resident() int {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return registry.lru.Len()
+}
+
+// save writes the document to the store if it changed since the last save.
+// Only a clone of the oplog is taken under the document lock.
+func (registry *Registry) save(entry *registryEntry) error {
+	entry.saveMu.Lock()
+	defer entry.saveMu.Unlock()
+
+	registry.mu.Lock()
+	saved, deleted := entry.saved, entry.deleted
+	registry.mu.Unlock()
+	if deleted {
+		return nil
+	}
+	var log *OpLog[rune]
+	entry.doc.View(func(doc *CRDTDocument) {
+		if len(doc.OpLog.Ops) != saved {
+			log = doc.OpLog.Clone()
+		}
+	})
+	if log == nil {
+		return nil
+	}
+
+	if err := registry.store.Save(entry.id, log); err != nil {
+		return err
+	}
+	registry.mu.Lock()
+	entry.saved = len(log.Ops)
+	registry.mu.Unlock()
+	return nil
+}
+
+// evictLocked picks idle documents to evict, least recently used first,
+// until at most capacity documents are resident once they are gone. The
+// caller saves and drops them with evict after releasing the lock.
+func (registry *Registry) evictLocked() []eviction {
+	var evictions []eviction
+	excess := registry.lru.Len() - registry.capacity
+	for el := registry.lru.Back(); excess > 0 && el != nil; el = el.Prev() {
+		entry := el.Value.(*registryEntry)
+		switch {
+		case entry.evicting:
+			excess--
+		case entry.refs == 0:
+			entry.evicting = true
+			evictions = append(evictions, eviction{entry: entry, opens: entry.opens})
+			excess--
+		}
+	}
+	return evictions
+}
+
+// evict saves and drops the documents picked by evictLocked. Documents
+// opened again in the meantime stay resident, and so do documents that fail
+// to save: their errors are kept for Flush.
+func (registry *Registry) evict(evictions []eviction) {
+	var errs []error
+	for _, ev := range evictions {
+		if err := registry.drop(ev); !errors.Is(err, ErrDocumentInUse) {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		registry.mu.Lock()
+		registry.evictErr = errors.Join(registry.evictErr, err)
+		registry.mu.Unlock()
+	}
+}
+
+// drop saves the document of ev and removes it from memory, or fails with
+// ErrDocumentInUse if it was opened again while it was being saved.
+func (registry *Registry) drop(ev eviction) error {
+	err := registry.save(ev.entry)
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	el, ok := registry.entries[ev.entry.id]
+	if !ok || el.Value.(*registryEntry) != ev.entry {
+		return nil // Dropped or deleted by someone else
+	}
+	if ev.entry.opens != ev.opens {
+		return ErrDocumentInUse
+	}
+	if err != nil {
+		ev.entry.evicting = false
+		return err
+	}
+	registry.lru.Remove(el)
+	delete(registry.entries, ev.entry.id)
+	return nil
+}
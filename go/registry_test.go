@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRegistryCreateOpenClose(t *testing.T) {
+	registry := NewRegistry(NewMemoryStore(), 1, 10)
+
+	doc, err := registry.Create("notes")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	doc.Ins(0, "hello")
+	if _, err := registry.Create("notes"); !errors.Is(err, ErrDocumentExists) {
+		t.Fatalf("Expected ErrDocumentExists, got %v", err)
+	}
+
+	if err := registry.Close("notes"); !errors.Is(err, ErrDocumentInUse) {
+		t.Fatalf("Expected ErrDocumentInUse, got %v", err)
+	}
+	registry.Release("notes")
+	if err := registry.Close("notes"); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if registry.Resident() != 0 {
+		t.Fatalf("Expected no resident documents, got %d", registry.Resident())
+	}
+
+	doc, err = registry.Open("notes")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer registry.Release("notes")
+	if doc.GetString() != "hello" {
+		t.Fatalf("Reopened document has %q", doc.GetString())
+	}
+	// The agent keeps counting from its last seq after a reload
+	doc.Ins(5, "!")
+	if doc.GetString() != "hello!" {
+		t.Fatalf("Unexpected text %q", doc.GetString())
+	}
+
+	if _, err := registry.Open("missing"); !errors.Is(err, ErrDocumentNotFound) {
+		t.Fatalf("Expected ErrDocumentNotFound, got %v", err)
+	}
+}
+
+func TestRegistryLRUEviction(t *testing.T) {
+	store := NewMemoryStore()
+	registry := NewRegistry(store, 1, 2)
+
+	for i := range 5 {
+		id := fmt.Sprintf("doc-%d", i)
+		doc, err := registry.Create(id)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		doc.Ins(0, id)
+		registry.Release(id)
+	}
+	if registry.Resident() != 2 {
+		t.Fatalf("Expected 2 resident documents, got %d", registry.Resident())
+	}
+	stored, _ := store.List()
+	if !slices.Equal(stored, []string{"doc-0", "doc-1", "doc-2"}) {
+		t.Fatalf("Expected the 3 oldest documents to be evicted, got %v", stored)
+	}
+
+	// Documents in use are never evicted
+	held, _ := registry.Open("doc-0")
+	for i := 1; i < 5; i++ {
+		id := fmt.Sprintf("doc-%d", i)
+		registry.Open(id)
+		registry.Release(id)
+	}
+	if held.GetString() != "doc-0" {
+		t.Fatalf("Unexpected text %q", held.GetString())
+	}
+	again, _ := registry.Open("doc-0")
+	if again != held {
+		t.Fatalf("Held document was evicted")
+	}
+	registry.Release("doc-0")
+	registry.Release("doc-0")
+
+	ids, err := registry.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(ids) != 5 {
+		t.Fatalf("Expected 5 documents, got %v", ids)
+	}
+
+	if err := registry.Delete("doc-3"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := registry.Open("doc-3"); !errors.Is(err, ErrDocumentNotFound) {
+		t.Fatalf("Expected ErrDocumentNotFound after Delete, got %v", err)
+	}
+}
+
+func TestRegistryConcurrentOpen(t *testing.T) {
+	store := NewMemoryStore()
+	log := NewOpLog[rune]()
+	LocalInsert(log, 0, 0, []rune("shared"))
+	store.Save("shared", log)
+
+	registry := NewRegistry(store, 1, 1)
+	docs := make([]*ConcurrentDocument, 16)
+	var wg sync.WaitGroup
+	for i := range docs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doc, err := registry.Open("shared")
+			if err != nil {
+				t.Errorf("Open failed: %v", err)
+				return
+			}
+			docs[i] = doc
+		}()
+	}
+	wg.Wait()
+	for _, doc := range docs[1:] {
+		if doc != docs[0] {
+			t.Fatalf("Concurrent opens returned different documents")
+		}
+	}
+}
+
+// slowStore is a MemoryStore whose saves wait until they are let through,
+// and which counts loads.
+type slowStore struct {
+	*MemoryStore
+	saving  chan string
+	proceed chan struct{}
+	loads   atomic.Int32
+}
+
+func (store *slowStore) Save(id string, log *OpLog[rune]) error {
+	store.saving <- id
+	<-store.proceed
+	return store.MemoryStore.Save(id, log)
+}
+
+func (store *slowStore) Load(id string) (*OpLog[rune], error) {
+	store.loads.Add(1)
+	return store.MemoryStore.Load(id)
+}
+
+func TestRegistrySavesWithoutLock(t *testing.T) {
+	store := &slowStore{MemoryStore: NewMemoryStore(), saving: make(chan string), proceed: make(chan struct{})}
+	registry := NewRegistry(store, 1, 0)
+
+	doc, _ := registry.Create("a")
+	doc.Ins(0, "evicted")
+	released := make(chan struct{})
+	go func() {
+		registry.Release("a")
+		close(released)
+	}()
+	if id := <-store.saving; id != "a" {
+		t.Fatalf("Expected a save of a, got %s", id)
+	}
+
+	// Other documents can be created and opened while "a" is being saved
+	if _, err := registry.Create("b"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := registry.Open("b"); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := registry.Create("a"); !errors.Is(err, ErrDocumentExists) {
+		t.Fatalf("Expected ErrDocumentExists while a is evicted, got %v", err)
+	}
+	close(store.proceed)
+	<-released
+
+	if registry.Resident() != 1 {
+		t.Fatalf("Expected only b to be resident, got %d documents", registry.Resident())
+	}
+	if _, err := registry.Create("a"); !errors.Is(err, ErrDocumentExists) {
+		t.Fatalf("Expected ErrDocumentExists for a stored document, got %v", err)
+	}
+	if store.loads.Load() != 0 {
+		t.Fatalf("Create loaded %d documents to check that they exist", store.loads.Load())
+	}
+	doc, err := registry.Open("a")
+	if err != nil || doc.GetString() != "evicted" {
+		t.Fatalf("Reopened a has %q, %v", doc.GetString(), err)
+	}
+}
+
+func TestRegistryReopenDuringEviction(t *testing.T) {
+	store := &slowStore{MemoryStore: NewMemoryStore(), saving: make(chan string), proceed: make(chan struct{})}
+	registry := NewRegistry(store, 1, 0)
+
+	doc, _ := registry.Create("a")
+	doc.Ins(0, "kept")
+	released := make(chan struct{})
+	go func() {
+		registry.Release("a")
+		close(released)
+	}()
+	<-store.saving
+
+	// Opened again before the save finished: the eviction is called off
+	again, err := registry.Open("a")
+	if err != nil || again != doc {
+		t.Fatalf("Reopen returned another document: %v", err)
+	}
+	close(store.proceed)
+	<-released
+	if registry.Resident() != 1 {
+		t.Fatalf("Reopened document was evicted")
+	}
+
+	// The next eviction saves the edits made since
+	go func() {
+		for range store.saving {
+		}
+	}()
+	again.Ins(4, "!")
+	registry.Release("a")
+	if registry.Resident() != 0 {
+		t.Fatalf("Released document was not evicted")
+	}
+	stored, _ := store.Load("a")
+	if string(Checkout(stored)) != "kept!" {
+		t.Fatalf("Unexpected stored content %q", string(Checkout(stored)))
+	}
+}
+
+// failingStore is a MemoryStore whose saves fail while fail is set.
+type failingStore struct {
+	*MemoryStore
+	fail atomic.Bool
+}
+
+var errDiskFull = errors.New("disk full")
+
+func (store *failingStore) Save(id string, log *OpLog[rune]) error {
+	if store.fail.Load() {
+		return errDiskFull
+	}
+	return store.MemoryStore.Save(id, log)
+}
+
+func TestRegistryEvictionErrors(t *testing.T) {
+	store := &failingStore{MemoryStore: NewMemoryStore()}
+	store.fail.Store(true)
+	registry := NewRegistry(store, 1, 1)
+
+	a, _ := registry.Create("a")
+	a.Ins(0, "unsaved")
+	registry.Release("a")
+
+	// Evicting a fails, which is not the error of whoever created b
+	b, err := registry.Create("b")
+	if err != nil || b == nil {
+		t.Fatalf("Create failed because of an eviction: %v", err)
+	}
+	registry.Release("b")
+	if registry.Resident() != 2 {
+		t.Fatalf("Expected a to stay resident, got %d documents", registry.Resident())
+	}
+	if err := registry.Flush(); !errors.Is(err, errDiskFull) {
+		t.Fatalf("Expected Flush to report the failed eviction, got %v", err)
+	}
+
+	// b was released, so it can be closed once the store works again
+	store.fail.Store(false)
+	if err := registry.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if err := registry.Close(id); err != nil {
+			t.Fatalf("Close of %s failed: %v", id, err)
+		}
+	}
+	if registry.Resident() != 0 {
+		t.Fatalf("Expected no resident documents, got %d", registry.Resident())
+	}
+	stored, _ := store.Load("a")
+	if string(Checkout(stored)) != "unsaved" {
+		t.Fatalf("Unexpected stored content %q", string(Checkout(stored)))
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	store, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDirStore failed: %v", err)
+	}
+	registry := NewRegistry(store, 1, 0)
+
+	doc, err := registry.Create("a/b c")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	doc.Ins(0, "on disk")
+	registry.Release("a/b c")
+
+	ids, _ := store.List()
+	if !slices.Equal(ids, []string{"a/b c"}) {
+		t.Fatalf("Unexpected ids %v", ids)
+	}
+	log, err := store.Load("a/b c")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if string(Checkout(log)) != "on disk" {
+		t.Fatalf("Unexpected content %q", string(Checkout(log)))
+	}
+	if err := store.Delete("a/b c"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Load("a/b c"); !errors.Is(err, ErrDocumentNotFound) {
+		t.Fatalf("Expected ErrDocumentNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+
+	"egwalker/websocket"
+)
+
+// ==========================================
+// Relay Server
+// ==========================================
+
+// The relay speaks the sync messages over WebSocket, one message per frame:
+//
+//	client -> hello{version}   server -> hello{version}
+//	                           server -> ops{everything the client lacks}
+//	client -> ops{everything the server lacks}, then ops{local ops} as they
+//	          are made         server -> ack{version}, and ops{...} to every
+//	                           other client of the document
+//
+// The server keeps each document checked out, so that a batch of ops that
+// does not fit it is rejected, with its sender disconnected, before any other
+// client sees it.
+
+var ErrRelayClosed = errors.New("relay connection closed")
+
+// relaySendBuffer is how many messages a client queues before local edits
+// block on the connection.
+const relaySendBuffer = 256
+
+type RelayServer struct {
+	mu   sync.Mutex
+	docs map[string]*relayDoc
+	mux  *http.ServeMux
+}
+
+type relayDoc struct {
+	mu      sync.Mutex
+	log     *OpLog[rune]
+	content *objReplay[rune] // Checked out up to the end of log
+	peers   map[*relayPeer]bool
+}
+
+// relayPeer is a client connection. Instead of a queue of messages it keeps
+// the version it is known to have: its writer wakes up, sends every op of
+// the log past that version in one message, and goes back to sleep. A slow
+// client thus gets bigger batches rather than an unbounded backlog.
+type relayPeer struct {
+	conn *websocket.Conn
+	wake chan struct{}
+
+	// Guarded by the document lock
+	version RemoteVersion
+	scanned int // ops of the log before this index were already considered
+	ack     bool
+}
+
+func NewRelayServer() *RelayServer {
+	server := &RelayServer{
+		docs: make(map[string]*relayDoc),
+		mux:  http.NewServeMux(),
+	}
+	server.mux.HandleFunc("GET /docs/{name}", server.serveDoc)
+	return server
+}
+
+func (server *RelayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+// Documents returns the names of the hosted documents, sorted.
+func (server *RelayServer) Documents() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return slices.Sorted(maps.Keys(server.docs))
+}
+
+// Text returns the current content of a hosted document.
+func (server *RelayServer) Text(name string) (string, bool) {
+	server.mu.Lock()
+	doc, ok := server.docs[name]
+	server.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	return string(*doc.content.snapshot), true
+}
+
+func (server *RelayServer) document(name string) *relayDoc {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	doc, ok := server.docs[name]
+	if !ok {
+		doc = &relayDoc{
+			log:     NewOpLog[rune](),
+			content: newObjReplay[rune](RootObj, true),
+			peers:   make(map[*relayPeer]bool),
+		}
+		server.docs[name] = doc
+	}
+	return doc
+}
+
+func (server *RelayServer) serveDoc(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	hello, err := readRelayMessage(conn)
+	if err != nil || hello.Type != SyncMessageHello {
+		return
+	}
+
+	doc := server.document(r.PathValue("name"))
+	peer := &relayPeer{
+		conn:    conn,
+		wake:    make(chan struct{}, 1),
+		version: hello.Version,
+	}
+	if peer.version == nil {
+		peer.version = make(RemoteVersion)
+	}
+	version := doc.join(peer)
+	defer doc.leave(peer)
+	// The writer is not running yet, so the hello is the first message
+	if err := writeRelayMessage(conn, SyncMessage{Type: SyncMessageHello, Version: version}); err != nil {
+		return
+	}
+	go doc.writeLoop(peer)
+
+	for {
+		msg, err := readRelayMessage(conn)
+		if err != nil {
+			return
+		}
+		if msg.Type != SyncMessageOps {
+			continue
+		}
+		if err := doc.receive(peer, msg.Ops); err != nil {
+			return
+		}
+	}
+}
+
+// join adds peer to the document and returns the version of the log.
+func (doc *relayDoc) join(peer *relayPeer) RemoteVersion {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	doc.peers[peer] = true
+	peer.notify()
+	return maps.Clone(doc.log.Version)
+}
+
+func (doc *relayDoc) leave(peer *relayPeer) {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+	delete(doc.peers, peer)
+	close(peer.wake)
+}
+
+// receive pushes ops from a peer into the document and wakes up every other
+// peer. Ops that do not fit the document are rolled back and never reach
+// the other peers.
+func (doc *relayDoc) receive(from *relayPeer, ops []WireOp[rune]) error {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	before := len(doc.log.Ops)
+	err := PushWireOps(doc.log, ops)
+	if checkoutErr := doc.checkout(); checkoutErr != nil {
+		doc.log.truncate(before)
+		doc.content = newObjReplay[rune](RootObj, true)
+		doc.checkout()
+		err = checkoutErr
+	}
+
+	for _, op := range ops {
+		if hasVersion(doc.log.Version, op.Id) && !hasVersion(from.version, op.Id) {
+			from.version[op.Id.Agent] = op.Id.Seq
+		}
+	}
+	if len(doc.log.Ops) > before {
+		for peer := range doc.peers {
+			if peer != from {
+				peer.notify()
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	from.ack = true
+	from.notify()
+	return nil
+}
+
+// checkout applies the ops pushed since the last call to the content. Ops
+// that do not fit it panic, which is returned as an error like tryCheckout
+// does; the content is left half updated in that case.
+func (doc *relayDoc) checkout() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrBadPosition, r)
+		}
+	}()
+	for lv := doc.content.next; lv < len(doc.log.Ops); lv++ {
+		doc.content.apply(doc.log, LV(lv))
+	}
+	return nil
+}
+
+// pending returns the messages to send to peer, and marks them as sent.
+func (doc *relayDoc) pending(peer *relayPeer) []SyncMessage {
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	var msgs []SyncMessage
+	ops := []WireOp[rune]{}
+	for _, op := range doc.log.Ops[peer.scanned:] {
+		if !hasVersion(peer.version, op.Id) {
+			ops = append(ops, ToWireOp(doc.log, op))
+			peer.version[op.Id.Agent] = op.Id.Seq
+		}
+	}
+	peer.scanned = len(doc.log.Ops)
+	if len(ops) > 0 {
+		msgs = append(msgs, SyncMessage{Type: SyncMessageOps, Ops: ops})
+	}
+	if peer.ack {
+		msgs = append(msgs, SyncMessage{Type: SyncMessageAck, Version: maps.Clone(doc.log.Version)})
+		peer.ack = false
+	}
+	return msgs
+}
+
+func (doc *relayDoc) writeLoop(peer *relayPeer) {
+	for range peer.wake {
+		for _, msg := range doc.pending(peer) {
+			if err := writeRelayMessage(peer.conn, msg); err != nil {
+				peer.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (peer *relayPeer) notify() {
+	select {
+	case peer.wake <- struct{}{}:
+	default:
+	}
+}
+
+func readRelayMessage(conn *websocket.Conn) (SyncMessage, error) {
+	var msg SyncMessage
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return msg, err
+	}
+	err = json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+func writeRelayMessage(conn *websocket.Conn, msg SyncMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// ==========================================
+// Relay Client
+// ==========================================
+
+// RelayClient keeps a document in sync with a relay server: local ops are
+// sent as they happen and ops from other clients are merged in the
+// background.
+type RelayClient struct {
+	doc  *ConcurrentDocument
+	conn *websocket.Conn
+	sub  *Subscription
+	send chan SyncMessage
+	done chan struct{}
+
+	mu            sync.Mutex
+	serverVersion RemoteVersion
+	err           error
+}
+
+// DialRelay connects doc to the document at url, e.g.
+// "ws://host/docs/notes". Ops the document already has, such as edits made
+// while offline, are uploaded first.
+func DialRelay(url string, doc *ConcurrentDocument) (*RelayClient, error) {
+	conn, err := websocket.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	client := &RelayClient{
+		doc:  doc,
+		conn: conn,
+		send: make(chan SyncMessage, relaySendBuffer),
+		done: make(chan struct{}),
+	}
+
+	var version RemoteVersion
+	doc.View(func(d *CRDTDocument) {
+		version = maps.Clone(d.OpLog.Version)
+	})
+	if err := writeRelayMessage(conn, SyncMessage{Type: SyncMessageHello, Version: version}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	hello, err := readRelayMessage(conn)
+	if err == nil && hello.Type != SyncMessageHello {
+		err = fmt.Errorf("%w: got %q, want %q", ErrUnexpectedMessage, hello.Type, SyncMessageHello)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client.serverVersion = hello.Version
+	if client.serverVersion == nil {
+		client.serverVersion = make(RemoteVersion)
+	}
+
+	// The upload and the subscription are set up under the write lock so
+	// that no local op falls between them, and none is sent before the ops
+	// it depends on.
+	doc.Update(func(d *CRDTDocument) {
+		if ops := OpsSince(d.OpLog, client.serverVersion); len(ops) > 0 {
+			client.send <- SyncMessage{Type: SyncMessageOps, Ops: ops}
+		}
+		client.sub = d.OnLocalOps(func(ops []Op[rune]) {
+			wireOps := make([]WireOp[rune], len(ops))
+			for i, op := range ops {
+				wireOps[i] = ToWireOp(d.OpLog, op)
+			}
+			select {
+			case client.send <- SyncMessage{Type: SyncMessageOps, Ops: wireOps}:
+			case <-client.done:
+			}
+		})
+	})
+
+	go client.writeLoop()
+	go client.readLoop()
+	return client, nil
+}
+
+func (client *RelayClient) writeLoop() {
+	for {
+		select {
+		case msg := <-client.send:
+			if err := writeRelayMessage(client.conn, msg); err != nil {
+				client.fail(err)
+				return
+			}
+		case <-client.done:
+			return
+		}
+	}
+}
+
+func (client *RelayClient) readLoop() {
+	for {
+		msg, err := readRelayMessage(client.conn)
+		if err != nil {
+			client.fail(err)
+			return
+		}
+		switch msg.Type {
+		case SyncMessageOps:
+			client.doc.Update(func(d *CRDTDocument) {
+				err = d.applyWireOps(msg.Ops)
+			})
+			if err != nil {
+				client.fail(err)
+				return
+			}
+		case SyncMessageAck:
+			client.mu.Lock()
+			client.serverVersion = msg.Version
+			client.mu.Unlock()
+		}
+	}
+}
+
+// ServerVersion is the server's version as of its last acknowledgement, or
+// its hello before that.
+func (client *RelayClient) ServerVersion() RemoteVersion {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return maps.Clone(client.serverVersion)
+}
+
+// Err returns the error that stopped the client, if any.
+func (client *RelayClient) Err() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.err
+}
+
+func (client *RelayClient) fail(err error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.err != nil {
+		return
+	}
+	client.err = err
+	client.sub.Unsubscribe()
+	close(client.done)
+	client.conn.Close()
+}
+
+func (client *RelayClient) Close() error {
+	client.fail(ErrRelayClosed)
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"maps"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"egwalker/websocket"
+)
+
+// waitConverged polls until every document has the same ops and text.
+func waitConverged(t *testing.T, docs ...*ConcurrentDocument) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		converged := true
+		var version RemoteVersion
+		var text string
+		for i, doc := range docs {
+			doc.View(func(d *CRDTDocument) {
+				if i == 0 {
+					version = maps.Clone(d.OpLog.Version)
+					text = d.GetString()
+				} else if !maps.Equal(version, d.OpLog.Version) || text != d.GetString() {
+					converged = false
+				}
+			})
+		}
+		if converged {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Documents did not converge")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRelayFanOut(t *testing.T) {
+	relay := NewRelayServer()
+	server := httptest.NewServer(relay)
+	defer server.Close()
+
+	docs := []*ConcurrentDocument{
+		NewConcurrentDocument(1),
+		NewConcurrentDocument(2),
+		NewConcurrentDocument(3),
+	}
+	for _, doc := range docs {
+		client, err := DialRelay(server.URL+"/docs/notes", doc)
+		if err != nil {
+			t.Fatalf("DialRelay failed: %v", err)
+		}
+		defer client.Close()
+	}
+
+	docs[0].Ins(0, "hello")
+	waitConverged(t, docs...)
+	docs[1].Ins(5, " world")
+	docs[2].Ins(0, ">> ")
+	waitConverged(t, docs...)
+
+	if got := docs[0].GetString(); got != ">> hello world" {
+		t.Fatalf("Unexpected text %q", got)
+	}
+	if text, ok := relay.Text("notes"); !ok || text != ">> hello world" {
+		t.Fatalf("Server has %q", text)
+	}
+}
+
+func TestRelayRandomEdits(t *testing.T) {
+	server := httptest.NewServer(NewRelayServer())
+	defer server.Close()
+
+	docs := make([]*ConcurrentDocument, 4)
+	for i := range docs {
+		docs[i] = NewConcurrentDocument(i)
+		client, err := DialRelay(server.URL+"/docs/fuzz", docs[i])
+		if err != nil {
+			t.Fatalf("DialRelay failed: %v", err)
+		}
+		defer client.Close()
+	}
+
+	r := rand.New(rand.NewSource(3))
+	alphabet := []rune("abcdefghijklmnopqrstuvwxyz")
+	for range 500 {
+		doc := docs[r.Intn(len(docs))]
+		doc.Update(func(d *CRDTDocument) {
+			length := len(d.Branch.Snapshot)
+			if length == 0 || r.Float64() < 0.6 {
+				d.Ins(r.Intn(length+1), string(alphabet[r.Intn(len(alphabet))]))
+			} else {
+				pos := r.Intn(length)
+				d.Del(pos, r.Intn(min(length-pos, 3))+1)
+			}
+		})
+	}
+	waitConverged(t, docs...)
+}
+
+func TestRelayLateJoinerAndIsolation(t *testing.T) {
+	relay := NewRelayServer()
+	server := httptest.NewServer(relay)
+	defer server.Close()
+
+	early := NewConcurrentDocument(1)
+	client, err := DialRelay(server.URL+"/docs/a", early)
+	if err != nil {
+		t.Fatalf("DialRelay failed: %v", err)
+	}
+	defer client.Close()
+	early.Ins(0, "abc")
+
+	other := NewConcurrentDocument(2)
+	otherClient, err := DialRelay(server.URL+"/docs/b", other)
+	if err != nil {
+		t.Fatalf("DialRelay failed: %v", err)
+	}
+	defer otherClient.Close()
+	other.Ins(0, "xyz")
+
+	// Wait for the server to acknowledge the early client's ops
+	deadline := time.Now().Add(5 * time.Second)
+	for client.ServerVersion()[1] != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Server never acknowledged the ops")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	late := NewConcurrentDocument(3)
+	lateClient, err := DialRelay(server.URL+"/docs/a", late)
+	if err != nil {
+		t.Fatalf("DialRelay failed: %v", err)
+	}
+	defer lateClient.Close()
+	waitConverged(t, early, late)
+
+	if late.GetString() != "abc" {
+		t.Fatalf("Late joiner has %q", late.GetString())
+	}
+	if docs := relay.Documents(); len(docs) != 2 || docs[0] != "a" || docs[1] != "b" {
+		t.Fatalf("Unexpected documents %v", docs)
+	}
+}
+
+func TestRelayUploadsOfflineEdits(t *testing.T) {
+	relay := NewRelayServer()
+	server := httptest.NewServer(relay)
+	defer server.Close()
+
+	online := NewConcurrentDocument(1)
+	onlineClient, err := DialRelay(server.URL+"/docs/notes", online)
+	if err != nil {
+		t.Fatalf("DialRelay failed: %v", err)
+	}
+	defer onlineClient.Close()
+	online.Ins(0, "shared ")
+
+	// Edited before it ever connects, then edited again once connected
+	offline := NewConcurrentDocument(2)
+	offline.Ins(0, "offline")
+	client, err := DialRelay(server.URL+"/docs/notes", offline)
+	if err != nil {
+		t.Fatalf("DialRelay failed: %v", err)
+	}
+	defer client.Close()
+	offline.Update(func(d *CRDTDocument) {
+		d.Ins(len(d.Branch.Snapshot), "!")
+	})
+	waitConverged(t, online, offline)
+
+	if err := client.Err(); err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+	if text := offline.GetString(); text != "shared offline!" {
+		t.Fatalf("Unexpected text %q", text)
+	}
+	if text, _ := relay.Text("notes"); text != "shared offline!" {
+		t.Fatalf("Server has %q", text)
+	}
+}
+
+func TestRelayRejectsOpsThatDoNotFit(t *testing.T) {
+	relay := NewRelayServer()
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	url := server.URL + "/docs/notes"
+
+	good := NewConcurrentDocument(1)
+	client, err := DialRelay(url, good)
+	if err != nil {
+		t.Fatalf("DialRelay failed: %v", err)
+	}
+	defer client.Close()
+	good.Ins(0, "ab")
+	deadline := time.Now().Add(5 * time.Second)
+	for client.ServerVersion()[1] != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Server never acknowledged the ops")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A client sending a delete past the end of the document is cut off
+	conn, err := websocket.Dial("ws" + strings.TrimPrefix(url, "http"))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	writeRelayMessage(conn, SyncMessage{Type: SyncMessageHello})
+	bad := WireOp[rune]{Type: OpTypeDel, Pos: 50, Id: Id{Agent: 9, Seq: 0}, Parents: []Id{{Agent: 1, Seq: 1}}}
+	writeRelayMessage(conn, SyncMessage{Type: SyncMessageOps, Ops: []WireOp[rune]{bad}})
+	for {
+		msg, err := readRelayMessage(conn)
+		if err != nil {
+			break
+		}
+		if msg.Type == SyncMessageAck {
+			t.Fatalf("Server acknowledged a bad op")
+		}
+	}
+
+	// Existing and new clients never see the op and keep working
+	late := NewConcurrentDocument(2)
+	lateClient, err := DialRelay(url, late)
+	if err != nil {
+		t.Fatalf("DialRelay failed: %v", err)
+	}
+	defer lateClient.Close()
+	waitConverged(t, good, late)
+	late.Ins(2, "c")
+	good.Ins(0, ">")
+	waitConverged(t, good, late)
+
+	for _, c := range []*RelayClient{client, lateClient} {
+		if err := c.Err(); err != nil {
+			t.Fatalf("Client failed: %v", err)
+		}
+	}
+	if text, _ := relay.Text("notes"); text != ">abc" || good.GetString() != ">abc" {
+		t.Fatalf("Server has %q, client %q", text, good.GetString())
+	}
+	if _, ok := relay.docs["notes"].log.Version[9]; ok {
+		t.Fatalf("Server kept the bad op")
+	}
+}
+
+func TestRelayRejectsPlainHTTP(t *testing.T) {
+	server := httptest.NewServer(NewRelayServer())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/docs/a")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// ==========================================
+// Change Subscriptions
+// ==========================================
+
+// TextPatch is a change to the text of a CRDTDocument: DelLen runes are
+// removed at Pos, then Text is inserted there. Positions are rune indices.
+type TextPatch struct {
+	Pos    int
+	DelLen int
+	Text   string
+}
+
+// Subscription is returned by the On* methods of Document. Callbacks are
+// called synchronously, after the change has been applied.
+type Subscription struct {
+	unsubscribe func()
+	once        sync.Once
+}
+
+// Unsubscribe removes the callback. It is safe to call more than once, from
+// any goroutine, including from inside the callback itself.
+func (sub *Subscription) Unsubscribe() {
+	sub.once.Do(sub.unsubscribe)
+}
+
+type listener[E any] struct {
+	id int
+	fn func(E)
+}
+
+// listenerList is a copy-on-write list of callbacks, so that emitting does
+// not hold the lock while user code runs.
+type listenerList[E any] struct {
+	mu      sync.Mutex
+	nextId  int
+	entries []listener[E]
+}
+
+func (list *listenerList[E]) add(fn func(E)) *Subscription {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	id := list.nextId
+	list.nextId++
+	list.entries = append(slices.Clip(list.entries), listener[E]{id: id, fn: fn})
+	return &Subscription{unsubscribe: func() {
+		list.mu.Lock()
+		defer list.mu.Unlock()
+		list.entries = slices.DeleteFunc(slices.Clone(list.entries), func(l listener[E]) bool {
+			return l.id == id
+		})
+	}}
+}
+
+func (list *listenerList[E]) emit(e E) {
+	list.mu.Lock()
+	entries := list.entries
+	list.mu.Unlock()
+	for _, l := range entries {
+		l.fn(e)
+	}
+}
+
+type documentListeners[T any] struct {
+	localOps  listenerList[[]Op[T]]
+	remoteOps listenerList[[]Op[T]]
+	patches   listenerList[Patch[T]]
+}
+
+// OnLocalOps registers fn to be called with the ops created by each Ins or
+// Del. The slice must not be modified.
+func (doc *Document[T]) OnLocalOps(fn func(ops []Op[T])) *Subscription {
+	return doc.listeners.localOps.add(fn)
+}
+
+// OnRemoteOps registers fn to be called with the ops a merge added to the
+// oplog. The slice must not be modified.
+func (doc *Document[T]) OnRemoteOps(fn func(ops []Op[T])) *Subscription {
+	return doc.listeners.remoteOps.add(fn)
+}
+
+// OnPatch registers fn to be called for every change to the content, local
+// or remote.
+func (doc *Document[T]) OnPatch(fn func(patch Patch[T])) *Subscription {
+	return doc.listeners.patches.add(fn)
+}
+
+// OnPatch registers fn to be called for every change to the text, local or
+// remote.
+func (doc *CRDTDocument) OnPatch(fn func(patch TextPatch)) *Subscription {
+	return doc.Document.OnPatch(func(p Patch[rune]) {
+		fn(TextPatch{Pos: p.Pos, DelLen: p.DelLen, Text: string(p.Content)})
+	})
+}
+
+func (doc *Document[T]) emitOps(list *listenerList[[]Op[T]], from int) {
+	if from == len(doc.OpLog.Ops) {
+		return
+	}
+	list.emit(doc.OpLog.Ops[from:len(doc.OpLog.Ops):len(doc.OpLog.Ops)])
+}
+
+func (doc *Document[T]) emitPatches(patches []Patch[T]) {
+	for _, p := range patches {
+		doc.listeners.patches.emit(p)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func applyTextPatch(text []rune, p TextPatch) []rune {
+	out := append([]rune{}, text[:p.Pos]...)
+	out = append(out, []rune(p.Text)...)
+	return append(out, text[p.Pos+p.DelLen:]...)
+}
+
+func TestSubscribeOps(t *testing.T) {
+	doc1 := NewCRDTDocument(1)
+	doc2 := NewCRDTDocument(2)
+
+	var local, remote int
+	sub1 := doc1.OnLocalOps(func(ops []Op[rune]) { local += len(ops) })
+	doc1.OnRemoteOps(func(ops []Op[rune]) { remote += len(ops) })
+
+	doc1.Ins(0, "hello")
+	doc1.Del(0, 1)
+	doc2.Ins(0, "yo")
+	doc1.MergeFrom(doc2)
+	doc1.MergeFrom(doc2) // Nothing new, no event
+
+	if local != 6 || remote != 2 {
+		t.Fatalf("Expected 6 local and 2 remote ops, got %d and %d", local, remote)
+	}
+
+	sub1.Unsubscribe()
+	sub1.Unsubscribe()
+	doc1.Ins(0, "x")
+	if local != 6 {
+		t.Fatalf("Callback called after Unsubscribe")
+	}
+}
+
+func TestSubscribePatchesFollowText(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	alphabet := []rune("abcdefghijklmnopqrstuvwxyz")
+
+	docs := []*CRDTDocument{NewCRDTDocument(0), NewCRDTDocument(1), NewCRDTDocument(2)}
+	mirrors := make([][]rune, len(docs))
+	for i, doc := range docs {
+		doc.OnPatch(func(p TextPatch) {
+			mirrors[i] = applyTextPatch(mirrors[i], p)
+		})
+	}
+
+	for i := range 300 {
+		doc := docs[r.Intn(len(docs))]
+		length := len(doc.Branch.Snapshot)
+		if length == 0 || r.Float64() < 0.6 {
+			doc.Ins(r.Intn(length+1), string(alphabet[r.Intn(len(alphabet))]))
+		} else {
+			pos := r.Intn(length)
+			doc.Del(pos, r.Intn(min(length-pos, 3))+1)
+		}
+
+		a, b := docs[r.Intn(len(docs))], docs[r.Intn(len(docs))]
+		if r.Float64() < 0.3 && a != b {
+			a.MergeFrom(b)
+		}
+
+		for j, doc := range docs {
+			if string(mirrors[j]) != doc.GetString() {
+				t.Fatalf("Iteration %d: patches for doc %d give %q, document is %q", i, j, string(mirrors[j]), doc.GetString())
+			}
+		}
+	}
+}
+
+func TestSubscribeUnsubscribeInsideCallback(t *testing.T) {
+	doc := NewCRDTDocument(0)
+	calls := 0
+	var sub *Subscription
+	sub = doc.OnPatch(func(p TextPatch) {
+		calls++
+		sub.Unsubscribe()
+	})
+	doc.Ins(0, "a")
+	doc.Ins(0, "b")
+	if calls != 1 {
+		t.Fatalf("Expected 1 call, got %d", calls)
+	}
+}
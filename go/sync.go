@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+)
+
+// ==========================================
+// Wire Format
+// ==========================================
+
+var (
+	ErrMessageTooLarge   = errors.New("sync message too large")
+	ErrUnexpectedMessage = errors.New("unexpected sync message")
+	ErrMissingParent     = errors.New("op references an unknown parent")
+	ErrSeqGap            = errors.New("op seq numbers are not contiguous")
+	ErrBadAnchor         = errors.New("mark anchored to an unknown item")
+	ErrUnknownObject     = errors.New("op references an unknown object")
+	ErrUnknownOpType     = errors.New("op has an unknown type")
+	ErrBadPosition       = errors.New("op position does not fit the document")
+)
+
+// MaxMessageSize bounds the payload of a single frame.
+const MaxMessageSize = 64 << 20
+
+// WireOp is an op as sent between replicas: parents are Ids, because LVs are
+// local to each oplog.
+type WireOp[T any] struct {
+	Type    OpType    `json:"type"`
+	Content T         `json:"content"`
+	Pos     int       `json:"pos"`
+	To      int       `json:"to,omitempty"`
+	Mark    *WireMark `json:"mark,omitempty"`
+	Key     string    `json:"key,omitempty"`
+	Value   any       `json:"value,omitempty"`
+	Obj     *Id       `json:"obj,omitempty"`
+	Make    ObjKind   `json:"make,omitempty"`
+	Id      Id        `json:"id"`
+	Parents []Id      `json:"parents"`
+}
+
+// WireMark is a Mark with its anchors as Ids. A nil Item is the edge of the
+// document.
+type WireMark struct {
+	Start WireAnchor `json:"start"`
+	End   WireAnchor `json:"end"`
+	Key   string     `json:"key"`
+	Value any        `json:"value"`
+}
+
+type WireAnchor struct {
+	Item  *Id  `json:"item,omitempty"`
+	After bool `json:"after,omitempty"`
+}
+
+func toWireAnchor[T any](log *OpLog[T], a Anchor) WireAnchor {
+	if a.Item == -1 {
+		return WireAnchor{After: a.After}
+	}
+	id := log.Ops[a.Item].Id
+	return WireAnchor{Item: &id, After: a.After}
+}
+
+// fromWireAnchor resolves an anchor against log. The item must be an insert
+// or a move the log already has.
+func fromWireAnchor[T any](log *OpLog[T], a WireAnchor) (Anchor, error) {
+	if a.Item == nil {
+		return Anchor{Item: -1, After: a.After}, nil
+	}
+	if !hasVersion(log.Version, *a.Item) {
+		return Anchor{}, fmt.Errorf("%w: %v", ErrBadAnchor, *a.Item)
+	}
+	lv := IdToLV(log, *a.Item)
+	if t := log.Ops[lv].Type; t != OpTypeIns && t != OpTypeMove {
+		return Anchor{}, fmt.Errorf("%w: %v is a %s", ErrBadAnchor, *a.Item, t)
+	}
+	return Anchor{Item: lv, After: a.After}, nil
+}
+
+// OpsSince returns, in causal order, every op of log that a replica at
+// version does not have yet.
+func OpsSince[T any](log *OpLog[T], version RemoteVersion) []WireOp[T] {
+	ops := []WireOp[T]{}
+	for _, op := range log.Ops {
+		if hasVersion(version, op.Id) {
+			continue
+		}
+		ops = append(ops, ToWireOp(log, op))
+	}
+	return ops
+}
+
+// ToWireOp converts an op of log, translating its parents to Ids.
+func ToWireOp[T any](log *OpLog[T], op Op[T]) WireOp[T] {
+	parents := make([]Id, len(op.Parents))
+	for i, p := range op.Parents {
+		parents[i] = log.Ops[p].Id
+	}
+	wop := WireOp[T]{
+		Type:    op.Type,
+		Content: op.Content,
+		Pos:     op.Pos,
+		Id:      op.Id,
+		Parents: parents,
+	}
+	if op.Ext == nil {
+		return wop
+	}
+	wop.To, wop.Key, wop.Value, wop.Make = op.Ext.To, op.Ext.Key, op.Ext.Value, op.Ext.Make
+	if op.Ext.Obj != RootObj {
+		id := log.Ops[op.Ext.Obj-1].Id
+		wop.Obj = &id
+	}
+	if mark := op.Ext.Mark; mark != nil {
+		wop.Mark = &WireMark{
+			Start: toWireAnchor(log, mark.Start),
+			End:   toWireAnchor(log, mark.End),
+			Key:   mark.Key,
+			Value: mark.Value,
+		}
+	}
+	return wop
+}
+
+// hasVersion reports whether a replica at version has the op with this id.
+func hasVersion(version RemoteVersion, id Id) bool {
+	seq, ok := version[id.Agent]
+	return ok && seq >= id.Seq
+}
+
+// PushWireOps adds ops received from another replica to log. Ops already
+// known are skipped. Unlike PushRemoteOp, malformed input is reported as an
+// error instead of a panic; ops before the faulty one are kept.
+func PushWireOps[T any](log *OpLog[T], ops []WireOp[T]) error {
+	for _, wop := range ops {
+		if hasVersion(log.Version, wop.Id) {
+			continue
+		}
+		lastSeq, ok := log.Version[wop.Id.Agent]
+		if !ok {
+			lastSeq = -1
+		}
+		if wop.Id.Seq != lastSeq+1 {
+			return fmt.Errorf("%w: agent %d seq %d after %d", ErrSeqGap, wop.Id.Agent, wop.Id.Seq, lastSeq)
+		}
+		for _, p := range wop.Parents {
+			if !hasVersion(log.Version, p) {
+				return fmt.Errorf("%w: %v", ErrMissingParent, p)
+			}
+		}
+		switch wop.Type {
+		case OpTypeIns, OpTypeDel, OpTypeMove, OpTypeMark, OpTypeSet:
+		default:
+			return fmt.Errorf("%w: %q", ErrUnknownOpType, wop.Type)
+		}
+		if wop.Pos < 0 || wop.To < 0 {
+			return fmt.Errorf("%w: %v at %d", ErrBadPosition, wop.Id, wop.Pos)
+		}
+		op := Op[T]{
+			Type:    wop.Type,
+			Content: wop.Content,
+			Pos:     wop.Pos,
+			Id:      wop.Id,
+		}
+		ext := OpExt{To: wop.To, Key: wop.Key, Value: wop.Value, Make: wop.Make}
+		if wop.Obj != nil {
+			if !hasVersion(log.Version, *wop.Obj) {
+				return fmt.Errorf("%w: %v", ErrUnknownObject, *wop.Obj)
+			}
+			lv := IdToLV(log, *wop.Obj)
+			if log.Ops[lv].Make() == "" {
+				return fmt.Errorf("%w: %v does not create an object", ErrUnknownObject, *wop.Obj)
+			}
+			ext.Obj = objOf(lv)
+		}
+		if wop.Type == OpTypeMark {
+			if wop.Mark == nil {
+				return fmt.Errorf("%w: mark op %v has no mark", ErrBadAnchor, wop.Id)
+			}
+			start, err := fromWireAnchor(log, wop.Mark.Start)
+			if err != nil {
+				return err
+			}
+			end, err := fromWireAnchor(log, wop.Mark.End)
+			if err != nil {
+				return err
+			}
+			ext.Mark = &Mark{Start: start, End: end, Key: wop.Mark.Key, Value: wop.Mark.Value}
+		}
+		if (wop.Type != OpTypeIns && wop.Type != OpTypeDel) || ext.Obj != RootObj || ext.Make != "" {
+			op.Ext = &ext
+		}
+		PushRemoteOp(log, op, wop.Parents)
+	}
+	return nil
+}
+
+// tryCheckout is CheckoutFancy for ops from a peer. Ops can pass
+// PushWireOps and still not fit the document (e.g. a delete past its end);
+// the panic this causes is returned as an error. branch is left half
+// updated in that case.
+func tryCheckout[T any](log *OpLog[T], branch *Branch[T]) (patches []Patch[T], err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrBadPosition, r)
+		}
+	}()
+	return CheckoutFancy(log, branch, log.Frontier), nil
+}
+
+type SyncMessageType string
+
+const (
+	SyncMessageHello SyncMessageType = "hello"
+	SyncMessageOps   SyncMessageType = "ops"
+	SyncMessageAck   SyncMessageType = "ack"
+)
+
+type SyncMessage struct {
+	Type    SyncMessageType `json:"type"`
+	Version RemoteVersion   `json:"version,omitempty"`
+	Ops     []WireOp[rune]  `json:"ops,omitempty"`
+}
+
+// WriteSyncMessage writes msg as a frame: a big-endian uint32 length followed
+// by the JSON payload.
+func WriteSyncMessage(w io.Writer, msg SyncMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if len(payload) > MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err = w.Write(frame)
+	return err
+}
+
+func ReadSyncMessage(r io.Reader) (SyncMessage, error) {
+	var msg SyncMessage
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return msg, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxMessageSize {
+		return msg, ErrMessageTooLarge
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return msg, err
+	}
+	err := json.Unmarshal(payload, &msg)
+	return msg, err
+}
+
+// ==========================================
+// Sync Session
+// ==========================================
+
+// SyncSession synchronizes a document with a peer over any io.ReadWriter
+// (a net.Conn, a net.Pipe, ...). Both peers run Sync at the same time; one
+// round is:
+//
+//	-> hello{version}  <- hello{version}
+//	-> ops{missing}    <- ops{missing}
+//	-> ack{version}    <- ack{version}
+//
+// after which both documents contain the same ops.
+type SyncSession struct {
+	doc         *CRDTDocument
+	rw          io.ReadWriter
+	peerVersion RemoteVersion
+}
+
+func NewSyncSession(doc *CRDTDocument, rw io.ReadWriter) *SyncSession {
+	return &SyncSession{
+		doc:         doc,
+		rw:          rw,
+		peerVersion: make(RemoteVersion),
+	}
+}
+
+// PeerVersion is the version the peer acknowledged in the last round.
+func (s *SyncSession) PeerVersion() RemoteVersion {
+	return maps.Clone(s.peerVersion)
+}
+
+func (s *SyncSession) Sync() error {
+	// Writes happen on their own goroutine: on a synchronous transport such
+	// as net.Pipe both peers would otherwise block writing their hello.
+	out := make(chan SyncMessage, 3)
+	errc := make(chan error, 1)
+	go func() {
+		for msg := range out {
+			if err := WriteSyncMessage(s.rw, msg); err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- nil
+	}()
+
+	err := s.exchange(out)
+	close(out)
+	if err != nil {
+		return err
+	}
+	return <-errc
+}
+
+func (s *SyncSession) exchange(out chan<- SyncMessage) error {
+	out <- SyncMessage{Type: SyncMessageHello, Version: maps.Clone(s.doc.OpLog.Version)}
+
+	hello, err := s.expect(SyncMessageHello)
+	if err != nil {
+		return err
+	}
+	out <- SyncMessage{Type: SyncMessageOps, Ops: OpsSince(s.doc.OpLog, hello.Version)}
+
+	ops, err := s.expect(SyncMessageOps)
+	if err != nil {
+		return err
+	}
+	if err := s.doc.applyWireOps(ops.Ops); err != nil {
+		return err
+	}
+	out <- SyncMessage{Type: SyncMessageAck, Version: maps.Clone(s.doc.OpLog.Version)}
+
+	ack, err := s.expect(SyncMessageAck)
+	if err != nil {
+		return err
+	}
+	s.peerVersion = ack.Version
+	if s.peerVersion == nil {
+		s.peerVersion = make(RemoteVersion)
+	}
+	return nil
+}
+
+func (s *SyncSession) expect(t SyncMessageType) (SyncMessage, error) {
+	msg, err := ReadSyncMessage(s.rw)
+	if err != nil {
+		return msg, err
+	}
+	if msg.Type != t {
+		return msg, fmt.Errorf("%w: got %q, want %q", ErrUnexpectedMessage, msg.Type, t)
+	}
+	return msg, nil
+}
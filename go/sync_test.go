@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func syncPair(t *testing.T, a, b *CRDTDocument) {
+	t.Helper()
+	connA, connB := net.Pipe()
+	defer connA.Close()
+	defer connB.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- NewSyncSession(b, connB).Sync()
+	}()
+	if err := NewSyncSession(a, connA).Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Peer sync failed: %v", err)
+	}
+}
+
+func TestSyncSessionPipe(t *testing.T) {
+	doc1 := NewCRDTDocument(1)
+	doc2 := NewCRDTDocument(2)
+	doc1.Ins(0, "hello")
+	doc2.Ins(0, "world")
+	doc2.Del(0, 1)
+
+	syncPair(t, doc1, doc2)
+	if doc1.GetString() != doc2.GetString() {
+		t.Fatalf("Documents differ after sync: %q vs %q", doc1.GetString(), doc2.GetString())
+	}
+
+	// A second round with nothing new is a no-op
+	before := doc1.GetString()
+	syncPair(t, doc1, doc2)
+	if doc1.GetString() != before || doc2.GetString() != before {
+		t.Fatalf("Empty sync changed the documents")
+	}
+}
+
+func TestSyncSessionRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	alphabet := []rune("abcdefghijklmnopqrstuvwxyz")
+	docs := []*CRDTDocument{NewCRDTDocument(0), NewCRDTDocument(1), NewCRDTDocument(2)}
+
+	for i := range 100 {
+		for range 3 {
+			doc := docs[r.Intn(len(docs))]
+			length := len(doc.Branch.Snapshot)
+			if length == 0 || r.Float64() < 0.6 {
+				doc.Ins(r.Intn(length+1), string(alphabet[r.Intn(len(alphabet))]))
+			} else {
+				pos := r.Intn(length)
+				doc.Del(pos, r.Intn(min(length-pos, 3))+1)
+			}
+		}
+		a, b := docs[r.Intn(len(docs))], docs[r.Intn(len(docs))]
+		if a == b {
+			continue
+		}
+		syncPair(t, a, b)
+		if a.GetString() != b.GetString() {
+			t.Fatalf("Iteration %d: documents differ after sync", i)
+		}
+	}
+}
+
+func TestSyncSessionPeerVersion(t *testing.T) {
+	doc1 := NewCRDTDocument(1)
+	doc2 := NewCRDTDocument(2)
+	doc1.Ins(0, "abc")
+
+	connA, connB := net.Pipe()
+	defer connA.Close()
+	defer connB.Close()
+	go NewSyncSession(doc2, connB).Sync()
+
+	session := NewSyncSession(doc1, connA)
+	if err := session.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if v := session.PeerVersion(); v[1] != 2 {
+		t.Fatalf("Expected peer to acknowledge seq 2 of agent 1, got %v", v)
+	}
+}
+
+func TestPushWireOpsRejectsGaps(t *testing.T) {
+	src := NewOpLog[rune]()
+	LocalInsert(src, 1, 0, []rune("abc"))
+	ops := OpsSince(src, nil)
+
+	dest := NewOpLog[rune]()
+	err := PushWireOps(dest, ops[1:])
+	if !errors.Is(err, ErrSeqGap) {
+		t.Fatalf("Expected ErrSeqGap, got %v", err)
+	}
+
+	bad := ops[0]
+	bad.Parents = []Id{{Agent: 9, Seq: 0}}
+	err = PushWireOps(dest, []WireOp[rune]{bad})
+	if !errors.Is(err, ErrMissingParent) {
+		t.Fatalf("Expected ErrMissingParent, got %v", err)
+	}
+
+	if err := PushWireOps(dest, ops); err != nil {
+		t.Fatalf("PushWireOps failed: %v", err)
+	}
+	if string(Checkout(dest)) != "abc" {
+		t.Fatalf("Unexpected content %q", string(Checkout(dest)))
+	}
+}
+
+func TestPushWireOpsRejectsMalformed(t *testing.T) {
+	log := NewOpLog[rune]()
+	for _, bad := range []struct {
+		op  WireOp[rune]
+		err error
+	}{
+		{WireOp[rune]{Type: "bogus", Pos: 5, Id: Id{Agent: 1, Seq: 0}}, ErrUnknownOpType},
+		{WireOp[rune]{Type: OpTypeIns, Pos: -1, Id: Id{Agent: 1, Seq: 0}}, ErrBadPosition},
+		{WireOp[rune]{Type: OpTypeMove, To: -1, Id: Id{Agent: 1, Seq: 0}}, ErrBadPosition},
+	} {
+		if err := PushWireOps(log, []WireOp[rune]{bad.op}); !errors.Is(err, bad.err) {
+			t.Errorf("Expected %v for %+v, got %v", bad.err, bad.op, err)
+		}
+	}
+	if len(log.Ops) != 0 {
+		t.Fatalf("Malformed ops were added to the oplog")
+	}
+}
+
+func TestApplyWireOpsRollsBack(t *testing.T) {
+	src := NewCRDTDocument(1)
+	src.Ins(0, "abc")
+	doc := NewCRDTDocument(2)
+	if err := doc.applyWireOps(OpsSince(src.OpLog, nil)); err != nil {
+		t.Fatalf("applyWireOps failed: %v", err)
+	}
+	doc.Ins(3, "d")
+
+	// Well-formed, but there is nothing at position 5 to delete
+	src.Ins(3, "x")
+	ops := append(OpsSince(src.OpLog, doc.OpLog.Version), WireOp[rune]{
+		Type:    OpTypeDel,
+		Pos:     5,
+		Id:      Id{Agent: 1, Seq: 4},
+		Parents: []Id{{Agent: 1, Seq: 3}},
+	})
+	if err := doc.applyWireOps(ops); !errors.Is(err, ErrBadPosition) {
+		t.Fatalf("Expected ErrBadPosition, got %v", err)
+	}
+	if len(doc.OpLog.Ops) != 4 || doc.OpLog.Version[1] != 2 || len(doc.OpLog.Frontier) != 1 || doc.OpLog.Frontier[0] != 3 {
+		t.Fatalf("Oplog not rolled back: %d ops, version %v, frontier %v", len(doc.OpLog.Ops), doc.OpLog.Version, doc.OpLog.Frontier)
+	}
+	if doc.GetString() != "abcd" {
+		t.Fatalf("Unexpected text %q", doc.GetString())
+	}
+
+	// The document keeps working, including with the good ops of the batch
+	if err := doc.applyWireOps(ops[:1]); err != nil {
+		t.Fatalf("applyWireOps failed: %v", err)
+	}
+	doc.Ins(0, ">")
+	if text := string(Checkout(doc.OpLog)); doc.GetString() != text || (text != ">abcxd" && text != ">abcdx") {
+		t.Fatalf("Unexpected text %q, checkout has %q", doc.GetString(), text)
+	}
+}
+
+func TestWireOpsKeepTextOpsSmall(t *testing.T) {
+	a := NewCRDTDocument(1)
+	a.Ins(0, "abc")
+	a.Del(1, 1)
+	a.Move(0, 1)
+	a.Set("k", "v")
+
+	b := NewCRDTDocument(2)
+	if err := b.applyWireOps(OpsSince(a.OpLog, nil)); err != nil {
+		t.Fatalf("applyWireOps failed: %v", err)
+	}
+	for _, log := range []*OpLog[rune]{a.OpLog, b.OpLog} {
+		for _, op := range log.Ops {
+			if plain := op.Type == OpTypeIns || op.Type == OpTypeDel; plain != (op.Ext == nil) {
+				t.Fatalf("%s op has payload %v", op.Type, op.Ext)
+			}
+		}
+	}
+	if b.GetString() != a.GetString() || b.OpLog.Ops[4].To() != 1 || b.OpLog.Ops[5].Key() != "k" {
+		t.Fatalf("Ops changed through the wire: %q vs %q", b.GetString(), a.GetString())
+	}
+}
+
+func TestSyncMessageFraming(t *testing.T) {
+	var buf bytes.Buffer
+	msg := SyncMessage{Type: SyncMessageHello, Version: RemoteVersion{3: 14}}
+	if err := WriteSyncMessage(&buf, msg); err != nil {
+		t.Fatalf("WriteSyncMessage failed: %v", err)
+	}
+	got, err := ReadSyncMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadSyncMessage failed: %v", err)
+	}
+	if got.Type != msg.Type || got.Version[3] != 14 {
+		t.Fatalf("Round trip mismatch: %+v", got)
+	}
+
+	if _, err := ReadSyncMessage(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff})); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("Expected ErrMessageTooLarge, got %v", err)
+	}
+}
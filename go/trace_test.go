@@ -1,6 +1,7 @@
 package main
 
 import (
+	"egwalker/bxtree"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -43,18 +44,25 @@ func (e *Edit) UnmarshalJSON(buf []byte) error {
 	return nil
 }
 
-func TestTrace(t *testing.T) {
+func loadTrace() (*Trace, error) {
 	json_file, err := os.Open("../resources/editing-trace.json")
 	if err != nil {
-		t.Fatalf("Failed to open JSON file: %v", err)
+		return nil, fmt.Errorf("Failed to open JSON file: %v", err)
 	}
 	defer json_file.Close()
 
 	var trace Trace
 	decoder := json.NewDecoder(json_file)
-	err = decoder.Decode(&trace)
+	if err := decoder.Decode(&trace); err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON file: %v", err)
+	}
+	return &trace, nil
+}
+
+func TestTrace(t *testing.T) {
+	trace, err := loadTrace()
 	if err != nil {
-		t.Fatalf("Failed to decode JSON file: %v", err)
+		t.Fatal(err)
 	}
 
 	//for _, edit := range trace.Edits[10050:10090] {
@@ -100,3 +108,70 @@ func TestTrace(t *testing.T) {
 		t.Fatalf("Mismatch, got '%q'", document.GetString())
 	}
 }
+
+// crdtItem stands in for a large struct element, such as a CRDT item, in
+// the node size benchmarks.
+type crdtItem struct {
+	content rune
+	id      Id
+	origin  [2]LV
+	deleted bool
+}
+
+// replayTrace types the trace into trees made by newTree.
+func replayTrace[T any](b *testing.B, trace *Trace, newTree func() *bxtree.BxTree[T], item func(rune) T) {
+	for b.Loop() {
+		tree := newTree()
+		for _, edit := range trace.Edits {
+			if edit.IsInsert {
+				tree.InsertAt(edit.Position, item([]rune(edit.Char)[0]))
+			} else {
+				tree.DeleteAt(edit.Position)
+			}
+		}
+	}
+}
+
+func BenchmarkTraceNodeSizes(b *testing.B) {
+	trace, err := loadTrace()
+	if err != nil {
+		b.Skip(err)
+	}
+	for _, leaf := range []int{8, 16, 32, 64, 128, 256} {
+		for _, internal := range []int{4, 16, 64} {
+			opts := []bxtree.Option{bxtree.WithLeafSize(leaf), bxtree.WithInternalSize(internal)}
+			b.Run(fmt.Sprintf("runes/leaf=%d/internal=%d", leaf, internal), func(b *testing.B) {
+				replayTrace(b, trace, func() *bxtree.BxTree[rune] { return bxtree.New[rune](opts...) },
+					func(r rune) rune { return r })
+			})
+			b.Run(fmt.Sprintf("items/leaf=%d/internal=%d", leaf, internal), func(b *testing.B) {
+				replayTrace(b, trace, func() *bxtree.BxTree[crdtItem] { return bxtree.New[crdtItem](opts...) },
+					func(r rune) crdtItem { return crdtItem{content: r} })
+			})
+		}
+	}
+}
+
+// BenchmarkTraceFillFactors loads the final text of the trace at different
+// fill factors and types the whole trace again into it, which is always
+// valid since the text only grows by what the trace adds.
+func BenchmarkTraceFillFactors(b *testing.B) {
+	trace, err := loadTrace()
+	if err != nil {
+		b.Skip(err)
+	}
+	text := []rune(trace.FinalText)
+	for _, leaf := range []int{32, 64, 128} {
+		for _, fill := range []float64{0.5, 0.75, 1} {
+			b.Run(fmt.Sprintf("leaf=%d/fill=%.2f", leaf, fill), func(b *testing.B) {
+				replayTrace(b, trace, func() *bxtree.BxTree[rune] {
+					tree, err := bxtree.FromSlice(text, fill, bxtree.WithLeafSize(leaf))
+					if err != nil {
+						b.Fatal(err)
+					}
+					return tree
+				}, func(r rune) rune { return r })
+			})
+		}
+	}
+}
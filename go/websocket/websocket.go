@@ -0,0 +1,306 @@
+// Package websocket implements the parts of RFC 6455 the relay server needs:
+// the opening handshake, masked/unmasked data frames, fragmentation, ping/pong
+// and close. Extensions and subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	continuationFrame = 0
+	TextMessage       = 1
+	BinaryMessage     = 2
+	CloseMessage      = 8
+	PingMessage       = 9
+	PongMessage       = 10
+)
+
+// MaxMessageSize bounds the size of a (reassembled) message.
+const MaxMessageSize = 64 << 20
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrBadHandshake    = errors.New("websocket: bad handshake")
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+	ErrProtocol        = errors.New("websocket: protocol error")
+)
+
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // clients mask their frames, servers don't
+
+	wmu sync.Mutex
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade performs the server side of the handshake and takes over the
+// connection. On failure an HTTP error has already been written to w.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		key == "" {
+		http.Error(w, "websocket handshake expected", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+		return nil, ErrBadHandshake
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return &Conn{conn: netConn, br: rw.Reader}, nil
+}
+
+// Dial opens a client connection. ws:// and http:// URLs are accepted (the
+// latter so that httptest server URLs can be used directly).
+func Dial(rawURL string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws", "http":
+	default:
+		return nil, errors.New("websocket: unsupported scheme " + u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+
+	netConn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Host:       u.Host,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if err := req.Write(netConn); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		netConn.Close()
+		return nil, ErrBadHandshake
+	}
+	return &Conn{conn: netConn, br: br, client: true}, nil
+}
+
+//
+
+// WriteMessage sends data as a single frame. It is safe to call from several
+// goroutines.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.writeFrame(byte(messageType), data)
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	if !c.client {
+		frame = append(frame, payload...)
+	} else {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, ErrProtocol
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > MaxMessageSize {
+		return false, 0, nil, ErrMessageTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage returns the next text or binary message, reassembling
+// fragments and answering pings. A close frame from the peer is reported as
+// io.EOF.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	messageType := 0
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case PingMessage:
+			if err := c.WriteMessage(PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			c.WriteMessage(CloseMessage, payload)
+			return 0, nil, io.EOF
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, ErrProtocol
+			}
+			message = append(message, payload...)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, ErrProtocol
+			}
+			messageType = int(opcode)
+			message = payload
+		default:
+			return 0, nil, ErrProtocol
+		}
+		if len(message) > MaxMessageSize {
+			return 0, nil, ErrMessageTooLarge
+		}
+		if fin {
+			return messageType, message, nil
+		}
+	}
+}
+
+// Close sends a close frame, best effort, and closes the connection. The close
+// frame is skipped if another goroutine is blocked writing.
+func (c *Conn) Close() error {
+	if c.wmu.TryLock() {
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(CloseMessage, nil)
+		c.wmu.Unlock()
+	}
+	return c.conn.Close()
+}
@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func echoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestEcho(t *testing.T) {
+	server := echoServer()
+	defer server.Close()
+
+	conn, err := Dial(server.URL)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// One size per length encoding: 7 bit, 16 bit and 64 bit
+	for _, size := range []int{10, 1000, 70000} {
+		data := bytes.Repeat([]byte{byte(size)}, size)
+		if err := conn.WriteMessage(BinaryMessage, data); err != nil {
+			t.Fatalf("WriteMessage failed: %v", err)
+		}
+		// Pings are answered transparently
+		if err := conn.WriteMessage(PingMessage, []byte("ping")); err != nil {
+			t.Fatalf("Ping failed: %v", err)
+		}
+		messageType, got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage failed: %v", err)
+		}
+		if messageType != BinaryMessage || !bytes.Equal(got, data) {
+			t.Fatalf("Echo mismatch for size %d", size)
+		}
+	}
+}
+
+func TestFragmentedMessage(t *testing.T) {
+	server := echoServer()
+	defer server.Close()
+
+	conn, err := Dial(server.URL)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// "hel" (text, not final) + "lo" (continuation, final), masked with a
+	// zero key so the payload stays readable.
+	frames := []byte{
+		TextMessage, 0x80 | 3, 0, 0, 0, 0, 'h', 'e', 'l',
+		0x80 | continuationFrame, 0x80 | 2, 0, 0, 0, 0, 'l', 'o',
+	}
+	if _, err := conn.conn.Write(frames); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	messageType, got, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if messageType != TextMessage || string(got) != "hello" {
+		t.Fatalf("Expected text \"hello\", got %d %q", messageType, got)
+	}
+}
+
+func TestBadHandshake(t *testing.T) {
+	server := echoServer()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", resp.StatusCode)
+	}
+}